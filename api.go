@@ -0,0 +1,486 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// ==========================================
+// REST API (/api/v1)
+// ==========================================
+// 所有响应均为 JSON；列表返回 {"data": [...], "meta": {...}}，错误返回 {"error": "..."}。
+// 鉴权使用后台登录会话或 Authorization: Bearer 令牌 (见 token.go)，权限规则与后台一致 (见 role.go)。
+
+const (
+	apiDefaultPerPage = 10
+	apiMaxPerPage     = 100
+)
+
+// 未登录也可读取的站点设置
+var publicOptionKeys = []string{"site_title", "site_description", "site_url", "site_keywords", "posts_per_page"}
+
+type apiTerm struct {
+	ID   uint   `json:"id"`
+	Name string `json:"name"`
+	Slug string `json:"slug"`
+}
+
+type apiPost struct {
+	ID          uint      `json:"id"`
+	Type        string    `json:"type"`
+	Title       string    `json:"title"`
+	Slug        string    `json:"slug"`
+	Content     string    `json:"content"`
+	Status      string    `json:"status"`
+	URL         string    `json:"url"`
+	AuthorID    uint      `json:"author_id"`
+	PublishedAt time.Time `json:"published_at"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	Categories  []apiTerm `json:"categories"`
+	Tags        []apiTerm `json:"tags"`
+}
+
+type apiUser struct {
+	ID        uint      `json:"id"`
+	Username  string    `json:"username"`
+	Nickname  string    `json:"nickname"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type apiMeta struct {
+	Page       int    `json:"page"`
+	PerPage    int    `json:"per_page"`
+	Total      int64  `json:"total"`
+	TotalPages int    `json:"total_pages"`
+	Prev       string `json:"prev,omitempty"`
+	Next       string `json:"next,omitempty"`
+}
+
+// apiPostInput 创建 / 更新文章的请求体，省略的字段保持不变
+type apiPostInput struct {
+	Type        string    `json:"type"`
+	Title       *string   `json:"title"`
+	Slug        *string   `json:"slug"`
+	Content     *string   `json:"content"`
+	Status      *string   `json:"status"`
+	PublishedAt *string   `json:"published_at"`
+	Categories  *[]uint   `json:"categories"`
+	Tags        *[]string `json:"tags"`
+}
+
+type apiUserInput struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	// CurrentPassword 修改自己的密码时需要
+	CurrentPassword string  `json:"current_password"`
+	Nickname        *string `json:"nickname"`
+	Role            *string `json:"role"`
+}
+
+func toAPIPost(p Post) apiPost {
+	out := apiPost{
+		ID: p.ID, Type: p.Type, Title: p.Title, Slug: p.Slug, Content: p.Content, Status: p.Status,
+		URL: postURL("", p), AuthorID: p.AuthorID, PublishedAt: p.PublishedAt, CreatedAt: p.CreatedAt, UpdatedAt: p.UpdatedAt,
+		Categories: []apiTerm{}, Tags: []apiTerm{},
+	}
+	for _, cat := range p.Categories {
+		out.Categories = append(out.Categories, apiTerm{ID: cat.ID, Name: cat.Name, Slug: cat.Slug})
+	}
+	for _, tag := range p.Tags {
+		out.Tags = append(out.Tags, apiTerm{ID: tag.ID, Name: tag.Name, Slug: tag.Slug})
+	}
+	return out
+}
+
+func toAPIUser(u User) apiUser {
+	return apiUser{ID: u.ID, Username: u.Username, Nickname: u.Nickname, Role: u.Role, CreatedAt: u.CreatedAt}
+}
+
+// apiError 统一的错误响应
+func apiError(c *fiber.Ctx, status int, msg string) error {
+	return c.Status(status).JSON(fiber.Map{"error": msg})
+}
+
+// apiSaveError 将保存时的错误转换为对应的状态码
+func apiSaveError(c *fiber.Ctx, err error) error {
+	var inErr *InputError
+	switch {
+	case errors.As(err, &inErr):
+		return apiError(c, 422, inErr.Message)
+	case errors.Is(err, ErrForbidden), errors.Is(err, ErrSessionRequired):
+		return apiError(c, 403, err.Error())
+	}
+	return apiError(c, 500, err.Error())
+}
+
+// apiPaginate 读取 page / per_page 参数并分页，翻页链接保留其余查询参数
+func apiPaginate(c *fiber.Ctx, query *gorm.DB) (*gorm.DB, apiMeta) {
+	perPage := c.QueryInt("per_page", apiDefaultPerPage)
+	if perPage < 1 {
+		perPage = apiDefaultPerPage
+	}
+	if perPage > apiMaxPerPage {
+		perPage = apiMaxPerPage
+	}
+	query, p := Paginate(query, c.QueryInt("page", 1), perPage, func(n int) string {
+		args := fiber.AcquireArgs()
+		defer fiber.ReleaseArgs(args)
+		c.Request().URI().QueryArgs().CopyTo(args)
+		args.Set("page", strconv.Itoa(n))
+		return c.Path() + "?" + args.String()
+	})
+	return query, apiMeta{Page: p.Page, PerPage: p.PerPage, Total: p.Total, TotalPages: p.TotalPages, Prev: p.PrevURL, Next: p.NextURL}
+}
+
+// apiSort 解析 sort 参数 (如 -published_at 表示倒序)，只允许 allowed 中的字段
+func apiSort(c *fiber.Ctx, allowed []string, def string) string {
+	for _, sort := range []string{c.Query("sort"), def} {
+		dir := "asc"
+		if strings.HasPrefix(sort, "-") {
+			sort, dir = sort[1:], "desc"
+		}
+		for _, f := range allowed {
+			if f == sort {
+				return f + " " + dir
+			}
+		}
+	}
+	return "id desc"
+}
+
+// apiAuth 从会话中加载当前用户 (未登录时继续，以匿名身份访问公开数据)
+// 携带令牌的请求已由 TokenAuth 加载用户
+func apiAuth(c *fiber.Ctx) error {
+	if c.Locals("user") != nil {
+		return c.Next()
+	}
+	if uid := currentUserID(c); uid != 0 {
+		var user User
+		if DB.First(&user, uid).Error == nil {
+			c.Locals("user", user)
+		}
+	}
+	return c.Next()
+}
+
+// apiRequireLogin 写操作要求登录
+func apiRequireLogin(c *fiber.Ctx) error {
+	if currentUser(c).ID == 0 {
+		return apiError(c, 401, "未登录")
+	}
+	return c.Next()
+}
+
+// RegisterAPI 注册 /api/v1 路由
+func RegisterAPI(app *fiber.App) {
+	// 通过会话 Cookie 登录的写操作同样需要 CSRF 令牌 (X-Csrf-Token 请求头)
+	csrfCheck := CSRFProtect()
+	api := app.Group("/api/v1", TokenAuth, apiAuth, func(c *fiber.Ctx) error {
+		if currentUser(c).ID == 0 {
+			return c.Next()
+		}
+		return csrfCheck(c)
+	})
+
+	api.Get("/openapi.json", func(c *fiber.Ctx) error {
+		c.Set("Content-Type", "application/json; charset=utf-8")
+		return c.SendString(openAPISpec)
+	})
+
+	// --- 文章 / 页面 ---
+	api.Get("/posts", func(c *fiber.Ctx) error {
+		user := currentUser(c)
+		postType := c.Query("type", "post")
+		if postType != "post" && postType != "page" {
+			return apiError(c, 400, "type 只能是 post 或 page")
+		}
+		query := DB.Model(&Post{}).Where("type = ?", postType)
+
+		// 有管理权限时可以看到所有状态 (作者 / 投稿者只能看到自己的文章)，否则只返回前台可见的内容
+		if user.Can(CapEditPosts) && (postType == "post" || user.Can(CapEditPages)) {
+			query = query.Scopes(AuthorScope(c))
+			if status := c.Query("status"); status != "" {
+				if _, ok := PostStatusLabels[status]; !ok {
+					return apiError(c, 400, "无效的 status")
+				}
+				query = query.Where("status = ?", status)
+			}
+		} else {
+			query = query.Scopes(VisibleScope(c))
+		}
+
+		if author := c.QueryInt("author"); author > 0 {
+			query = query.Where("author_id = ?", author)
+		}
+		if slug := c.Query("category"); slug != "" {
+			var cat Category
+			if err := DB.Where("slug = ?", slug).First(&cat).Error; err != nil {
+				return apiError(c, 400, "分类不存在")
+			}
+			query = query.Where("id IN (?)", DB.Table("post_categories").Select("post_id").Where("category_id IN ?", CategoryDescendantIDs(cat.ID)))
+		}
+		if slug := c.Query("tag"); slug != "" {
+			query = query.Where("id IN (?)", DB.Table("post_tags").Select("post_id").
+				Where("tag_id IN (?)", DB.Model(&Tag{}).Select("id").Where("slug = ?", slug)))
+		}
+		if q := strings.TrimSpace(c.Query("q")); q != "" {
+			ids, err := Search.Search(SearchTerms(q))
+			if err != nil {
+				ids = likeSearch(SearchTerms(q))
+			}
+			query = query.Where("id IN ?", append(ids, 0))
+		}
+
+		query, meta := apiPaginate(c, query)
+		var posts []Post
+		query.Preload("Categories").Preload("Tags").
+			Order(apiSort(c, []string{"id", "title", "created_at", "updated_at", "published_at"}, "-published_at")).Find(&posts)
+		data := make([]apiPost, 0, len(posts))
+		for _, p := range posts {
+			data = append(data, toAPIPost(p))
+		}
+		return c.JSON(fiber.Map{"data": data, "meta": meta})
+	})
+
+	// findPost 读取文章，不可见时返回 404
+	findPost := func(c *fiber.Ctx) (Post, error) {
+		var post Post
+		if err := DB.Preload("Categories").Preload("Tags").First(&post, c.Params("id")).Error; err != nil {
+			return post, err
+		}
+		if CanEditPost(c, post) {
+			return post, nil
+		}
+		return post, DB.Scopes(VisibleScope(c)).Where("id = ?", post.ID).First(&Post{}).Error
+	}
+
+	api.Get("/posts/:id<int>", func(c *fiber.Ctx) error {
+		post, err := findPost(c)
+		if err != nil {
+			return apiError(c, 404, "文章不存在")
+		}
+		return c.JSON(toAPIPost(post))
+	})
+
+	// savePost 创建与更新共用：先填入已有的值，再用请求体覆盖
+	savePost := func(c *fiber.Ctx, post Post) error {
+		var body apiPostInput
+		if err := c.BodyParser(&body); err != nil {
+			return apiError(c, 400, "请求体格式错误: "+err.Error())
+		}
+		in := PostInput{Title: post.Title, Slug: post.Slug, Content: post.Content, Type: body.Type, Status: post.Status}
+		if post.ID == 0 {
+			in.Status = StatusDraft // 未指定状态时创建为草稿
+		}
+		for _, f := range []struct {
+			dst *string
+			src *string
+		}{{&in.Title, body.Title}, {&in.Slug, body.Slug}, {&in.Content, body.Content}, {&in.Status, body.Status}, {&in.PublishedAt, body.PublishedAt}} {
+			if f.src != nil {
+				*f.dst = *f.src
+			}
+		}
+		if _, ok := PostStatusLabels[in.Status]; !ok {
+			return apiError(c, 422, "无效的 status")
+		}
+
+		created := post.ID == 0
+		if err := SavePost(c, &post, in); err != nil {
+			return apiSaveError(c, err)
+		}
+		if body.Categories != nil || body.Tags != nil {
+			catIDs := make([]uint, 0, len(post.Categories))
+			for _, cat := range post.Categories {
+				catIDs = append(catIDs, cat.ID)
+			}
+			tags := TagNames(post.Tags)
+			if body.Categories != nil {
+				catIDs = *body.Categories
+			}
+			if body.Tags != nil {
+				tags = strings.Join(*body.Tags, ",")
+			}
+			SetPostTaxonomy(&post, catIDs, tags)
+		}
+
+		DB.Preload("Categories").Preload("Tags").First(&post, post.ID)
+		if created {
+			c.Status(201)
+		}
+		return c.JSON(toAPIPost(post))
+	}
+
+	api.Post("/posts", apiRequireLogin, func(c *fiber.Ctx) error {
+		return savePost(c, Post{})
+	})
+	updatePost := func(c *fiber.Ctx) error {
+		var post Post
+		if err := DB.Preload("Categories").Preload("Tags").First(&post, c.Params("id")).Error; err != nil {
+			return apiError(c, 404, "文章不存在")
+		}
+		return savePost(c, post)
+	}
+	api.Put("/posts/:id<int>", apiRequireLogin, updatePost)
+	api.Patch("/posts/:id<int>", apiRequireLogin, updatePost)
+
+	api.Delete("/posts/:id<int>", apiRequireLogin, func(c *fiber.Ctx) error {
+		var post Post
+		if err := DB.First(&post, c.Params("id")).Error; err != nil {
+			return apiError(c, 404, "文章不存在")
+		}
+		if err := DeletePost(c, post); err != nil {
+			return apiSaveError(c, err)
+		}
+		return c.SendStatus(204)
+	})
+
+	// --- 站点设置 ---
+	visibleOptions := func(c *fiber.Ctx) map[string]string {
+		out := make(map[string]string)
+		if can(c, CapManageOptions) {
+			for k, v := range SiteSettings() {
+				out[k] = v
+			}
+			return out
+		}
+		for _, k := range publicOptionKeys {
+			out[k] = SiteSettings()[k]
+		}
+		return out
+	}
+	api.Get("/options", func(c *fiber.Ctx) error {
+		return c.JSON(visibleOptions(c))
+	})
+	api.Get("/options/:name", func(c *fiber.Ctx) error {
+		v, ok := visibleOptions(c)[c.Params("name")]
+		if !ok {
+			return apiError(c, 404, "设置项不存在")
+		}
+		return c.JSON(fiber.Map{"name": c.Params("name"), "value": v})
+	})
+	saveOptions := func(c *fiber.Ctx) error {
+		var body map[string]string
+		if err := c.BodyParser(&body); err != nil {
+			return apiError(c, 400, "请求体格式错误: "+err.Error())
+		}
+		for k := range body {
+			known := false
+			for _, key := range SiteSettingKeys {
+				known = known || key == k
+			}
+			if !known {
+				return apiError(c, 422, "未知的设置项: "+k)
+			}
+		}
+		if err := SaveSiteSettings(body); err != nil {
+			return apiError(c, 500, err.Error())
+		}
+		return c.JSON(visibleOptions(c))
+	}
+	api.Put("/options", apiRequireLogin, RequireCap(CapManageOptions), saveOptions)
+	api.Patch("/options", apiRequireLogin, RequireCap(CapManageOptions), saveOptions)
+
+	// --- 用户 ---
+	api.Get("/users/me", apiRequireLogin, func(c *fiber.Ctx) error {
+		user := currentUser(c)
+		caps := RoleCapabilities[user.Role]
+		if caps == nil {
+			caps = []string{}
+		}
+		return c.JSON(fiber.Map{"user": toAPIUser(user), "capabilities": caps})
+	})
+
+	users := api.Group("/users", apiRequireLogin, RequireCap(CapManageUsers))
+	users.Get("/", func(c *fiber.Ctx) error {
+		query := DB.Model(&User{})
+		if role := c.Query("role"); role != "" {
+			query = query.Where("role = ?", role)
+		}
+		if q := strings.TrimSpace(c.Query("q")); q != "" {
+			query = query.Where("username LIKE ? OR nickname LIKE ?", "%"+q+"%", "%"+q+"%")
+		}
+		query, meta := apiPaginate(c, query)
+		var list []User
+		query.Order(apiSort(c, []string{"id", "username", "created_at"}, "id")).Find(&list)
+		data := make([]apiUser, 0, len(list))
+		for _, u := range list {
+			data = append(data, toAPIUser(u))
+		}
+		return c.JSON(fiber.Map{"data": data, "meta": meta})
+	})
+	users.Get("/:id<int>", func(c *fiber.Ctx) error {
+		var user User
+		if err := DB.First(&user, c.Params("id")).Error; err != nil {
+			return apiError(c, 404, "用户不存在")
+		}
+		return c.JSON(toAPIUser(user))
+	})
+	saveUser := func(c *fiber.Ctx, user User) error {
+		var body apiUserInput
+		if err := c.BodyParser(&body); err != nil {
+			return apiError(c, 400, "请求体格式错误: "+err.Error())
+		}
+		nickname, role := user.Nickname, user.Role
+		if user.ID == 0 {
+			role = RoleAuthor
+		}
+		if body.Nickname != nil {
+			nickname = *body.Nickname
+		}
+		if body.Role != nil {
+			role = *body.Role
+		}
+		if body.Password != "" {
+			if err := CheckPasswordChange(c, user, body.CurrentPassword); err != nil {
+				return apiSaveError(c, err)
+			}
+		}
+		created := user.ID == 0
+		if err := SaveUser(&user, body.Username, body.Password, nickname, role); err != nil {
+			return apiSaveError(c, err)
+		}
+		if created {
+			c.Status(201)
+		} else if body.Password != "" {
+			revokeSessionsAfterReset(c, user)
+		}
+		return c.JSON(toAPIUser(user))
+	}
+	users.Post("/", func(c *fiber.Ctx) error {
+		return saveUser(c, User{})
+	})
+	updateUser := func(c *fiber.Ctx) error {
+		var user User
+		if err := DB.First(&user, c.Params("id")).Error; err != nil {
+			return apiError(c, 404, "用户不存在")
+		}
+		return saveUser(c, user)
+	}
+	users.Put("/:id<int>", updateUser)
+	users.Patch("/:id<int>", updateUser)
+	users.Delete("/:id<int>", func(c *fiber.Ctx) error {
+		var user User
+		if err := DB.First(&user, c.Params("id")).Error; err != nil {
+			return apiError(c, 404, "用户不存在")
+		}
+		if user.ID == currentUserID(c) {
+			return apiError(c, 422, "不能删除当前登录的账号")
+		}
+		if err := DeleteUser(user, currentUserID(c)); err != nil {
+			return apiSaveError(c, err)
+		}
+		return c.SendStatus(204)
+	})
+
+	// 未匹配的 API 路径同样返回 JSON
+	api.Use(func(c *fiber.Ctx) error {
+		return apiError(c, 404, "接口不存在")
+	})
+}
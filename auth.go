@@ -0,0 +1,36 @@
+package main
+
+import (
+	"github.com/gofiber/fiber/v2"
+)
+
+// isLoggedIn 判断当前请求是否来自已登录的后台用户
+func isLoggedIn(c *fiber.Ctx) bool {
+	return currentUserID(c) != 0
+}
+
+// currentUserID 返回当前登录用户的 ID，未登录返回 0
+// 通过 API 令牌鉴权的请求没有会话，直接使用令牌中间件加载的用户
+func currentUserID(c *fiber.Ctx) uint {
+	if u, ok := c.Locals("user").(User); ok && u.ID != 0 {
+		return u.ID
+	}
+	if store == nil {
+		return 0
+	}
+	sess, err := store.Get(c)
+	if err != nil {
+		return 0
+	}
+	switch v := sess.Get("user_id").(type) {
+	case uint:
+		return v
+	case int:
+		return uint(v)
+	case int64:
+		return uint(v)
+	case uint64:
+		return uint(v)
+	}
+	return 0
+}
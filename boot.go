@@ -3,7 +3,12 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"log"
 	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
 
 	"github.com/glebarez/sqlite"
 	"gorm.io/driver/mysql"
@@ -14,57 +19,137 @@ import (
 
 var DB *gorm.DB
 
+// 配置来源 (优先级从低到高): config.json、环境变量、命令行参数
+// 环境变量为 GOPRESS_ 加上 config.json 中的字段名 (大写)，如 GOPRESS_LISTEN、GOPRESS_DB_PASSWORD；
+// 命令行参数为字段名中的下划线换成连字符，如 --listen、--db-password。列表类型的字段用逗号分隔
+const envPrefix = "GOPRESS_"
+
+var (
+	// flagValues 命令行参数提供的配置 (json 字段名 -> 值)，每次加载配置时重新应用
+	flagValues = map[string]string{}
+	// configOverrides 由环境变量或命令行参数提供的字段，保存配置时不写入文件
+	configOverrides []string
+	// configMu 保护 configOverrides，并让 config.json 的写入依次进行
+	configMu sync.Mutex
+)
+
 func LoadConfig() bool {
+	cfg, overrides, _ := readConfig()
+	setConfigOverrides(overrides)
+	SetConfig(cfg)
+
+	return cfg.Installed
+}
+
+func setConfigOverrides(keys []string) {
+	configMu.Lock()
+	configOverrides = keys
+	configMu.Unlock()
+}
+
+// readConfig 读取 config.json 并应用环境变量与命令行参数，不修改当前配置
+// 从零值开始解析，已从文件中删除的配置项恢复默认；文件不存在时 err 为 nil
+func readConfig() (Config, []string, error) {
+	var cfg Config
 	file, err := os.ReadFile("config.json")
-	if err != nil {
-		return false
+	if err == nil {
+		err = json.Unmarshal(file, &cfg)
+	} else if os.IsNotExist(err) {
+		err = nil
 	}
-	json.Unmarshal(file, &GlobalConfig)
+	return cfg, applyConfigOverrides(&cfg), err
+}
+
+// configKey 返回字段在 config.json 中的名称
+func configKey(f reflect.StructField) string {
+	key, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+	return key
+}
 
-	themePath := "themes/" + GlobalConfig.Theme
-	if GlobalConfig.Theme == "" {
-		themePath = "themes/default"
+// applyConfigOverrides 用环境变量与命令行参数覆盖配置，返回被覆盖的字段
+func applyConfigOverrides(cfg *Config) []string {
+	var keys []string
+	v := reflect.ValueOf(cfg).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		key := configKey(v.Type().Field(i))
+		name := envPrefix + strings.ToUpper(key)
+		raw, ok := flagValues[key]
+		if !ok {
+			raw, ok = os.LookupEnv(name)
+		}
+		// GOPRESS_PORT 是 GOPRESS_LISTEN=:端口 的简写
+		if port := os.Getenv(envPrefix + "PORT"); !ok && key == "listen" && port != "" {
+			raw, ok = ":"+port, true
+		}
+		if !ok {
+			continue
+		}
+		if err := setConfigField(v.Field(i), raw); err != nil {
+			log.Printf("环境变量 %s 无效: %v", name, err)
+			continue
+		}
+		keys = append(keys, key)
 	}
+	return keys
+}
 
-	themeFile, err := os.ReadFile(themePath + "/config.json")
-	if err == nil {
-		json.Unmarshal(themeFile, &CurrentThemeConfig)
-	} else {
-		if err == nil {
-			json.Unmarshal(themeFile, &CurrentThemeConfig)
-		} else {
-			// 读取失败时的默认值，注意结构体字段已变
-			CurrentThemeConfig = ThemeConfig{
-				Name:        "Default",
-				Author:      "Admin",
-				Description: "Fallback theme",
-				// 初始化一个空的 map 防止空指针报错
-				Config: make(map[string]string),
+func setConfigField(f reflect.Value, raw string) error {
+	switch f.Kind() {
+	case reflect.String:
+		f.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		f.SetBool(b)
+	case reflect.Int:
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return err
+		}
+		f.SetInt(int64(n))
+	case reflect.Slice:
+		var list []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
 			}
 		}
+		f.Set(reflect.ValueOf(list))
+	default:
+		return fmt.Errorf("不支持的类型 %s", f.Kind())
 	}
-
-	return GlobalConfig.Installed
+	return nil
 }
 
 func ConnectDB() error {
 	var dialector gorm.Dialector
 
-	switch GlobalConfig.DBType {
+	// 配置了 db_dsn 时直接使用，否则由各个字段拼接
+	cfg := Cfg()
+	dsn := cfg.DBDSN
+	switch cfg.DBType {
 	case "mysql":
-		dsn := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?charset=utf8mb4&parseTime=True&loc=Local",
-			GlobalConfig.DBUser, GlobalConfig.DBPass, GlobalConfig.DBHost, GlobalConfig.DBPort, GlobalConfig.DBName)
+		if dsn == "" {
+			dsn = fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?charset=utf8mb4&parseTime=True&loc=Local",
+				cfg.DBUser, cfg.DBPass, cfg.DBHost, cfg.DBPort, cfg.DBName)
+		}
 		dialector = mysql.Open(dsn)
 	case "postgres":
-		dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%s sslmode=disable",
-			GlobalConfig.DBHost, GlobalConfig.DBUser, GlobalConfig.DBPass, GlobalConfig.DBName, GlobalConfig.DBPort)
+		if dsn == "" {
+			dsn = fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%s sslmode=disable",
+				cfg.DBHost, cfg.DBUser, cfg.DBPass, cfg.DBName, cfg.DBPort)
+		}
 		dialector = postgres.Open(dsn)
 	default:
-		dbFile := GlobalConfig.DBName
-		if dbFile == "" {
-			dbFile = "gopress.db"
+		if dsn == "" {
+			dsn = cfg.DBName
 		}
-		dialector = sqlite.Open(dbFile)
+		if dsn == "" {
+			dsn = "gopress.db"
+		}
+		dialector = sqlite.Open(dsn)
 	}
 
 	var err error
@@ -76,19 +161,93 @@ func ConnectDB() error {
 		return err
 	}
 
-	err = DB.AutoMigrate(&Post{}, &User{}, &Option{})
+	err = DB.AutoMigrate(&Post{}, &User{}, &Option{}, &Category{}, &Tag{}, &PostRevision{}, &Media{}, &Comment{}, &APIToken{}, &RecoveryCode{}, &LoginAttempt{}, &Session{}, &PluginData{})
 	if err != nil {
 		return err
 	}
 
+	// 旧数据没有发布时间，以创建时间补齐
+	DB.Model(&Post{}).Where("published_at IS NULL").Update("published_at", gorm.Expr("created_at"))
+
+	// 升级前只有管理员账号；旧文章归属到第一个用户
+	DB.Model(&User{}).Where("role IS NULL OR role = ?", "").Update("role", RoleAdmin)
+	var first User
+	if DB.Order("id asc").First(&first).Error == nil {
+		DB.Model(&Post{}).Where("author_id IS NULL OR author_id = ?", 0).Update("author_id", first.ID)
+	}
+
+	InitSearch()
 	return nil
 }
 
+// InstallInput 安装时创建的管理员与站点信息
+type InstallInput struct {
+	AdminUser, AdminPass, AdminNick string
+	SiteTitle, SiteDescription      string
+}
+
+// Install 连接数据库，创建管理员并写入站点设置，最后保存配置 (安装向导与 gopress install 共用)
+func Install(cfg Config, in InstallInput) error {
+	in.AdminUser = strings.TrimSpace(in.AdminUser)
+	if in.AdminUser == "" {
+		return inputError("请填写管理员用户名")
+	}
+	if len(in.AdminPass) < 8 {
+		return inputError("管理员密码至少 8 位")
+	}
+	if cfg.SecretKey == "" {
+		key, err := NewSecretKey()
+		if err != nil {
+			return err
+		}
+		cfg.SecretKey = key
+	}
+	SetConfig(cfg)
+	if err := ConnectDB(); err != nil {
+		return err
+	}
+	// 创建管理员
+	hash, err := HashPassword(in.AdminPass)
+	if err != nil {
+		return err
+	}
+	if err := DB.Create(&User{Username: in.AdminUser, Password: hash, Nickname: in.AdminNick, Role: RoleAdmin}).Error; err != nil {
+		return err
+	}
+	// 写入设置
+	DB.Save(&Option{Name: "site_title", Value: in.SiteTitle})
+	DB.Save(&Option{Name: "site_description", Value: in.SiteDescription})
+	// 保存配置
+	return SaveConfig(cfg)
+}
+
 func SaveConfig(cfg Config) error {
 	cfg.Installed = true
 	if cfg.Theme == "" {
 		cfg.Theme = "default"
 	}
+	configMu.Lock()
+	defer configMu.Unlock()
 	data, _ := json.MarshalIndent(cfg, "", "  ")
-	return os.WriteFile("config.json", data, 0644)
+	// 环境变量与命令行参数提供的值 (如数据库密码) 不写入文件，保留文件中原有的值
+	if len(configOverrides) > 0 {
+		var fields, onDisk map[string]json.RawMessage
+		json.Unmarshal(data, &fields)
+		if file, err := os.ReadFile("config.json"); err == nil {
+			json.Unmarshal(file, &onDisk)
+		}
+		for _, key := range configOverrides {
+			if v, ok := onDisk[key]; ok {
+				fields[key] = v
+			} else {
+				delete(fields, key)
+			}
+		}
+		data, _ = json.MarshalIndent(fields, "", "  ")
+	}
+	// 配置中含数据库密码与密钥，仅允许所有者读写；旧版本创建的文件先收紧权限再写入
+	if err := os.Chmod("config.json", 0o600); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.WriteFile("config.json", data, 0o600)
 }
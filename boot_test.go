@@ -0,0 +1,24 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+// 配置文件含数据库密码，保存时只允许所有者读写，旧版本创建的文件也会收紧权限
+func TestSaveConfigPermissions(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if err := os.WriteFile("config.json", []byte("{}"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := SaveConfig(Config{DBPass: "secret"}); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat("config.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Fatalf("config.json 权限为 %o, 期望 600", perm)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// 命令行用法:
+//
+//	gopress [参数]            启动站点
+//	gopress install [参数]    不经过浏览器完成安装 (创建管理员并写入站点设置)
+//
+// 通用参数: --data-dir 数据目录 (config.json、数据库、主题与上传文件所在目录，也可用 GOPRESS_DATA_DIR)、
+// --port 监听端口，以及 config.json 中的每个字段 (如 --db-type、--db-dsn、--theme、--listen)
+const cliUsage = `用法:
+  gopress [参数]            启动站点
+  gopress install [参数]    不经过浏览器完成安装
+
+参数:
+`
+
+// parseCommandLine 解析命令行参数，返回子命令 ("" 表示启动站点) 与安装参数
+func parseCommandLine(args []string) (string, InstallInput) {
+	cmd := ""
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cmd, args = args[0], args[1:]
+	}
+	fs := flag.NewFlagSet("gopress", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), cliUsage)
+		fs.PrintDefaults()
+	}
+	dataDir := fs.String("data-dir", os.Getenv(envPrefix+"DATA_DIR"), "数据目录，默认为当前目录")
+	fs.Func("port", "监听端口 (等同于 --listen :端口)", func(v string) error {
+		flagValues["listen"] = ":" + v
+		return nil
+	})
+	registerConfigFlags(fs)
+
+	var in InstallInput
+	if cmd == "install" {
+		fs.StringVar(&in.AdminUser, "admin-user", envOr("ADMIN_USER", "admin"), "管理员用户名")
+		fs.StringVar(&in.AdminPass, "admin-password", os.Getenv(envPrefix+"ADMIN_PASSWORD"), "管理员密码 (建议使用 GOPRESS_ADMIN_PASSWORD，避免出现在进程列表中)")
+		fs.StringVar(&in.AdminNick, "admin-nick", envOr("ADMIN_NICK", "站长"), "管理员昵称")
+		fs.StringVar(&in.SiteTitle, "site-title", envOr("SITE_TITLE", "My GoPress"), "网站标题")
+		fs.StringVar(&in.SiteDescription, "site-description", envOr("SITE_DESCRIPTION", "Simple is better."), "网站描述")
+	} else if cmd != "" {
+		fmt.Fprintf(os.Stderr, "未知的命令: %s\n\n", cmd)
+		fs.Usage()
+		os.Exit(2)
+	}
+	fs.Parse(args)
+
+	if *dataDir != "" {
+		if err := os.MkdirAll(*dataDir, 0755); err != nil {
+			log.Fatalln("数据目录创建失败:", err)
+		}
+		if err := os.Chdir(*dataDir); err != nil {
+			log.Fatalln("无法进入数据目录:", err)
+		}
+	}
+	return cmd, in
+}
+
+// registerConfigFlags 将 Config 的每个字段注册为命令行参数，值在每次加载配置时应用
+func registerConfigFlags(fs *flag.FlagSet) {
+	t := reflect.TypeOf(Config{})
+	for i := 0; i < t.NumField(); i++ {
+		key := configKey(t.Field(i))
+		name := strings.ReplaceAll(key, "_", "-")
+		usage := "覆盖 config.json 中的 " + key
+		set := func(v string) error {
+			// 解析时校验类型，避免启动后才发现参数无效
+			var probe Config
+			if err := setConfigField(reflect.ValueOf(&probe).Elem().FieldByIndex(t.Field(i).Index), v); err != nil {
+				return err
+			}
+			flagValues[key] = v
+			return nil
+		}
+		if t.Field(i).Type.Kind() == reflect.Bool {
+			fs.BoolFunc(name, usage, set)
+		} else {
+			fs.Func(name, usage, set)
+		}
+	}
+}
+
+func envOr(name, fallback string) string {
+	if v := os.Getenv(envPrefix + name); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// runInstallCommand 执行 gopress install：已安装时直接返回，便于在容器启动脚本中重复执行
+func runInstallCommand(in InstallInput) {
+	if LoadConfig() {
+		log.Println("站点已安装，跳过")
+		return
+	}
+	// 安装时通过命令行参数指定的配置写入 config.json，之后启动无需重复指定；环境变量提供的配置仍不写入
+	var keep []string
+	for _, key := range configOverrides {
+		if _, ok := flagValues[key]; !ok {
+			keep = append(keep, key)
+		}
+	}
+	setConfigOverrides(keep)
+	if err := Install(*Cfg(), in); err != nil {
+		log.Fatalln("安装失败:", err)
+	}
+	log.Printf("安装完成，管理员: %s", in.AdminUser)
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"errors"
+	"net/mail"
+	"strings"
+	"unicode/utf8"
+
+	"gopress/plugins"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// 评论状态
+const (
+	CommentPending  = "pending"
+	CommentApproved = "approved"
+	CommentSpam     = "spam"
+	CommentTrash    = "trash"
+)
+
+// CommentStatusLabels 后台展示用的状态名称 (按审核队列的顺序)
+var CommentStatusLabels = []struct{ Value, Label string }{
+	{CommentPending, "待审核"},
+	{CommentApproved, "已通过"},
+	{CommentSpam, "垃圾评论"},
+	{CommentTrash, "回收站"},
+}
+
+// 评论长度与展示层级的限制
+const (
+	maxCommentLength = 5000
+	maxCommentDepth  = 5 // 超过该层级的回复不再缩进
+)
+
+// IsValidCommentStatus 判断是否为合法的评论状态
+func IsValidCommentStatus(status string) bool {
+	for _, s := range CommentStatusLabels {
+		if s.Value == status {
+			return true
+		}
+	}
+	return false
+}
+
+// GetCommentTree 返回文章已通过的评论
+// 第一个返回值为顶级评论 (Children 中为回复)，第二个为按楼层展开的扁平列表 (带 Depth)，供不支持递归的模板使用
+func GetCommentTree(postID uint) ([]*Comment, []*Comment) {
+	var all []*Comment
+	DB.Where("post_id = ? AND status = ?", postID, CommentApproved).Order("created_at asc").Find(&all)
+
+	byID := make(map[uint]*Comment, len(all))
+	for _, cm := range all {
+		byID[cm.ID] = cm
+	}
+	var roots []*Comment
+	for _, cm := range all {
+		// 父评论不存在或未通过时作为顶级评论展示
+		if parent, ok := byID[cm.ParentID]; ok && cm.ParentID != 0 {
+			parent.Children = append(parent.Children, cm)
+		} else {
+			roots = append(roots, cm)
+		}
+	}
+
+	var flat []*Comment
+	var walk func(list []*Comment, depth int)
+	walk = func(list []*Comment, depth int) {
+		for _, cm := range list {
+			cm.Depth = depth
+			if cm.Depth > maxCommentDepth {
+				cm.Depth = maxCommentDepth
+			}
+			flat = append(flat, cm)
+			walk(cm.Children, depth+1)
+		}
+	}
+	walk(roots, 0)
+	return roots, flat
+}
+
+// AddCommentData 向文章页的模板数据中加入评论
+func AddCommentData(c *fiber.Ctx, post Post, data fiber.Map) fiber.Map {
+	tree, flat := GetCommentTree(post.ID)
+	data["CommentTree"] = tree
+	data["Comments"] = flat
+	data["CommentCount"] = len(flat)
+	data["CommentResult"] = c.Query("comment") // 提交后的提示: pending / approved / error
+	data["CommentError"] = c.Query("reason")
+	return data
+}
+
+// SubmitComment 校验并保存访客提交的评论，返回保存后的评论
+func SubmitComment(c *fiber.Ctx, post Post) (*Comment, error) {
+	cm := &Comment{
+		PostID:      post.ID,
+		AuthorName:  strings.TrimSpace(c.FormValue("author")),
+		AuthorEmail: strings.TrimSpace(c.FormValue("email")),
+		AuthorURL:   strings.TrimSpace(c.FormValue("url")),
+		Content:     strings.TrimSpace(c.FormValue("content")),
+		IP:          c.IP(),
+		UserAgent:   c.Get("User-Agent"),
+	}
+
+	// 登录用户直接使用账号信息
+	if uid := currentUserID(c); uid != 0 {
+		var user User
+		if DB.First(&user, uid).Error == nil {
+			cm.UserID = user.ID
+			cm.AuthorName = user.DisplayName()
+		}
+	}
+
+	if cm.AuthorName == "" || cm.Content == "" {
+		return nil, errors.New("请填写昵称和评论内容")
+	}
+	if utf8.RuneCountInString(cm.AuthorName) > 50 || utf8.RuneCountInString(cm.Content) > maxCommentLength {
+		return nil, errors.New("昵称或评论内容过长")
+	}
+	if cm.AuthorEmail != "" {
+		if _, err := mail.ParseAddress(cm.AuthorEmail); err != nil || len(cm.AuthorEmail) > 200 {
+			return nil, errors.New("邮箱格式不正确")
+		}
+	}
+	if cm.AuthorURL != "" && (len(cm.AuthorURL) > 200 || !(strings.HasPrefix(cm.AuthorURL, "http://") || strings.HasPrefix(cm.AuthorURL, "https://"))) {
+		return nil, errors.New("网址需以 http:// 或 https:// 开头")
+	}
+	if len(cm.UserAgent) > 500 {
+		cm.UserAgent = cm.UserAgent[:500]
+	}
+
+	// 只能回复同一篇文章下已通过的评论
+	if parentID := c.FormValue("parent_id"); parentID != "" && parentID != "0" {
+		var parent Comment
+		if err := DB.Where("id = ? AND post_id = ? AND status = ?", parentID, post.ID, CommentApproved).First(&parent).Error; err != nil {
+			return nil, errors.New("回复的评论不存在")
+		}
+		cm.ParentID = parent.ID
+	}
+
+	cm.Status = CommentPending
+	if cm.UserID != 0 || SiteSettings()["comment_moderation"] == "0" {
+		cm.Status = CommentApproved
+	}
+
+	// 交给插件 (如反垃圾过滤器) 处理，插件可以拒绝或修改评论
+	data := &plugins.CommentData{
+		PostID: cm.PostID, ParentID: cm.ParentID, Author: cm.AuthorName, Email: cm.AuthorEmail,
+		URL: cm.AuthorURL, Content: cm.Content, IP: cm.IP, UserAgent: cm.UserAgent, Status: cm.Status,
+	}
+	if reason, rejected := plugins.ApplyCommentFilter(data); rejected {
+		return nil, errors.New(reason)
+	}
+	cm.AuthorName, cm.AuthorEmail, cm.AuthorURL, cm.Content = data.Author, data.Email, data.URL, data.Content
+	if IsValidCommentStatus(data.Status) {
+		cm.Status = data.Status
+	}
+	if strings.TrimSpace(cm.Content) == "" {
+		return nil, errors.New("评论内容不能为空")
+	}
+
+	if err := DB.Create(cm).Error; err != nil {
+		return nil, err
+	}
+	return cm, nil
+}
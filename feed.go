@@ -0,0 +1,243 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// 每个订阅源输出的文章数
+const feedSize = 20
+
+// FeedSource 一个订阅源 (全站 / 分类 / 标签)
+type FeedSource struct {
+	Title       string
+	Description string
+	Link        string // 对应页面的路径，如 / 或 /category/go
+	Posts       []Post
+}
+
+// FeedQuery 订阅源的基础查询：公开文章，按发布时间倒序
+func FeedQuery() *gorm.DB {
+	return DB.Preload("Categories").Preload("Tags").Scopes(PublicScope).
+		Where("type = ?", "post").Order("published_at desc").Limit(feedSize)
+}
+
+// FeedLinks 返回某个页面对应的三种订阅地址，供主题的 <link rel="alternate"> 使用
+// prefix 为空表示全站订阅，分类/标签传入 /category/xxx、/tag/xxx
+func FeedLinks(prefix string) map[string]string {
+	if prefix == "" {
+		return map[string]string{"RSS": "/feed", "Atom": "/atom.xml", "JSON": "/feed.json"}
+	}
+	return map[string]string{"RSS": prefix + "/feed", "Atom": prefix + "/atom.xml", "JSON": prefix + "/feed.json"}
+}
+
+// === RSS 2.0 ===
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	AtomNS  string     `xml:"xmlns:atom,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	AtomLink      atomLink  `xml:"atom:link"`
+	LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+	Generator     string    `xml:"generator"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string   `xml:"title"`
+	Link        string   `xml:"link"`
+	GUID        string   `xml:"guid"`
+	PubDate     string   `xml:"pubDate"`
+	Categories  []string `xml:"category"`
+	Description string   `xml:"description"`
+}
+
+// === Atom ===
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomEntry struct {
+	Title     string         `xml:"title"`
+	ID        string         `xml:"id"`
+	Link      atomLink       `xml:"link"`
+	Published string         `xml:"published"`
+	Updated   string         `xml:"updated"`
+	Category  []atomCategory `xml:"category"`
+	Content   atomContent    `xml:"content"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+// postURL 返回文章的绝对地址
+func postURL(baseURL string, p Post) string {
+	if p.Type == "page" {
+		return baseURL + "/" + p.Slug
+	}
+	return baseURL + "/post/" + p.Slug
+}
+
+// feedContent 根据设置输出全文或摘要
+func feedContent(p Post) string {
+	if SiteSettings()["feed_fulltext"] == "1" {
+		return string(RenderMarkdown(p.Content))
+	}
+	return string(RenderSummary(p.Content))
+}
+
+func postTerms(p Post) []string {
+	terms := []string{}
+	for _, cat := range p.Categories {
+		terms = append(terms, cat.Name)
+	}
+	for _, tag := range p.Tags {
+		terms = append(terms, tag.Name)
+	}
+	return terms
+}
+
+// SendFeed 输出订阅源，format 为 rss / atom / json
+// 对内容计算 ETag，If-None-Match 命中时返回 304；Last-Modified 只作参考，
+// 文章被删除、下线或站点设置变化时最近更新时间不会随之变化，因此不用它判断 304
+func SendFeed(c *fiber.Ctx, format string, src FeedSource) error {
+	baseURL := c.Protocol() + "://" + c.Hostname()
+	selfURL := baseURL + c.Path()
+
+	var lastMod time.Time
+	for _, p := range src.Posts {
+		if p.UpdatedAt.After(lastMod) {
+			lastMod = p.UpdatedAt
+		}
+	}
+	if lastMod.IsZero() {
+		lastMod = time.Now()
+	}
+	lastMod = lastMod.UTC().Truncate(time.Second)
+
+	var body []byte
+	var contentType string
+	switch format {
+	case "atom":
+		feed := atomFeed{
+			Title:   src.Title,
+			ID:      baseURL + src.Link,
+			Updated: lastMod.Format(time.RFC3339),
+			Links: []atomLink{
+				{Href: baseURL + src.Link, Rel: "alternate", Type: "text/html"},
+				{Href: selfURL, Rel: "self", Type: "application/atom+xml"},
+			},
+		}
+		for _, p := range src.Posts {
+			entry := atomEntry{
+				Title:     p.Title,
+				ID:        postURL(baseURL, p),
+				Link:      atomLink{Href: postURL(baseURL, p), Rel: "alternate"},
+				Published: p.PublishedAt.Format(time.RFC3339),
+				Updated:   p.UpdatedAt.Format(time.RFC3339),
+				Content:   atomContent{Type: "html", Body: feedContent(p)},
+			}
+			for _, term := range postTerms(p) {
+				entry.Category = append(entry.Category, atomCategory{Term: term})
+			}
+			feed.Entries = append(feed.Entries, entry)
+		}
+		out, _ := xml.MarshalIndent(feed, "", "  ")
+		body = append([]byte(xml.Header), out...)
+		contentType = "application/atom+xml; charset=utf-8"
+	case "json":
+		items := make([]fiber.Map, 0, len(src.Posts))
+		for _, p := range src.Posts {
+			items = append(items, fiber.Map{
+				"id":             postURL(baseURL, p),
+				"url":            postURL(baseURL, p),
+				"title":          p.Title,
+				"content_html":   feedContent(p),
+				"date_published": p.PublishedAt.Format(time.RFC3339),
+				"date_modified":  p.UpdatedAt.Format(time.RFC3339),
+				"tags":           postTerms(p),
+			})
+		}
+		body, _ = json.MarshalIndent(fiber.Map{
+			"version":       "https://jsonfeed.org/version/1.1",
+			"title":         src.Title,
+			"description":   src.Description,
+			"home_page_url": baseURL + src.Link,
+			"feed_url":      selfURL,
+			"items":         items,
+		}, "", "  ")
+		contentType = "application/feed+json; charset=utf-8"
+	default:
+		feed := rssFeed{
+			Version: "2.0",
+			AtomNS:  "http://www.w3.org/2005/Atom",
+			Channel: rssChannel{
+				Title:         src.Title,
+				Link:          baseURL + src.Link,
+				Description:   src.Description,
+				AtomLink:      atomLink{Href: selfURL, Rel: "self", Type: "application/rss+xml"},
+				LastBuildDate: lastMod.Format(time.RFC1123Z),
+				Generator:     "GoPress",
+			},
+		}
+		for _, p := range src.Posts {
+			feed.Channel.Items = append(feed.Channel.Items, rssItem{
+				Title:       p.Title,
+				Link:        postURL(baseURL, p),
+				GUID:        postURL(baseURL, p),
+				PubDate:     p.PublishedAt.Format(time.RFC1123Z),
+				Categories:  postTerms(p),
+				Description: feedContent(p),
+			})
+		}
+		out, _ := xml.MarshalIndent(feed, "", "  ")
+		body = append([]byte(xml.Header), out...)
+		contentType = "application/rss+xml; charset=utf-8"
+	}
+
+	sum := sha1.Sum(body)
+	etag := `"` + hex.EncodeToString(sum[:]) + `"`
+	c.Set("ETag", etag)
+	c.Set("Last-Modified", lastMod.Format(http.TimeFormat))
+	c.Set("Cache-Control", "public, max-age=300")
+
+	if inm := c.Get("If-None-Match"); inm != "" && strings.Contains(inm, etag) {
+		return c.SendStatus(304)
+	}
+
+	c.Set("Content-Type", contentType)
+	return c.Send(body)
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"reflect"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"gopress/plugins"
+)
+
+// 进程生命周期：
+//   - SIGTERM / SIGINT: 就绪状态切换为不可用，停止接受新连接，等待进行中的请求完成后退出 (再次收到信号时立即退出)
+//     配置了 drain_delay 时先继续处理请求一段时间，再停止接受新连接
+//   - SIGHUP: 重新加载 config.json、站点设置、插件与主题，不重新监听端口
+const defaultDrainSeconds = 10
+
+// appEvent 通知 runApp 停止当前应用
+type appEvent int
+
+const (
+	eventStop    appEvent = iota // 退出进程
+	eventRestart                 // 重新创建应用 (安装完成后切换到博客模式)
+)
+
+var (
+	appEvents = make(chan appEvent, 1)
+	ready     atomic.Bool // 就绪状态，排空连接期间为 false
+)
+
+// DrainTimeout 返回退出时等待进行中请求的最长时间
+func DrainTimeout() time.Duration {
+	seconds := Cfg().DrainTimeout
+	if seconds <= 0 {
+		seconds = defaultDrainSeconds
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// RequestRestart 请求重新创建应用，当前请求会正常完成
+func RequestRestart() {
+	sendAppEvent(eventRestart)
+}
+
+func sendAppEvent(ev appEvent) {
+	select {
+	case appEvents <- ev:
+	default: // 已有待处理的事件
+	}
+}
+
+// HandleSignals 监听系统信号 (整个进程只调用一次)
+func HandleSignals() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	go func() {
+		stopping := false
+		for sig := range signals {
+			switch {
+			case sig == syscall.SIGHUP:
+				ReloadConfig()
+			case stopping:
+				log.Println("再次收到退出信号，立即退出")
+				os.Exit(1)
+			default:
+				stopping = true
+				log.Printf("收到 %s，等待进行中的请求完成 (最长 %s)...", sig, DrainTimeout())
+				sendAppEvent(eventStop)
+			}
+		}
+	}()
+}
+
+// serveApp 启动监听并阻塞，直到收到停止事件后优雅关闭；返回 true 表示需要重新创建应用
+func serveApp(app *fiber.App) bool {
+	ln, err := newListener()
+	if err != nil {
+		log.Println("监听失败:", err)
+		return false
+	}
+	log.Println("监听地址:", ln)
+	errc := make(chan error, 1)
+	go func() { errc <- app.Listener(ln) }()
+	ready.Store(true)
+
+	var ev appEvent
+	select {
+	case err := <-errc:
+		ready.Store(false)
+		log.Println(err)
+		return false
+	case ev = <-appEvents:
+	}
+
+	ready.Store(false)
+	if ev == eventStop && Cfg().DrainDelay > 0 {
+		time.Sleep(time.Duration(Cfg().DrainDelay) * time.Second)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), DrainTimeout())
+	defer cancel()
+	ln.Shutdown(ctx)
+	if err := app.ShutdownWithContext(ctx); err != nil {
+		log.Println("关闭时仍有未完成的请求:", err)
+	}
+	<-errc
+	return ev == eventRestart
+}
+
+// ReloadConfig 重新加载 config.json、站点设置、插件与主题 (SIGHUP)
+// 新的配置与站点设置先读入局部变量，都读取成功后才整体替换，失败时继续使用原配置
+// 数据库连接、监听地址与会话 Cookie 等只在启动时读取的配置 (restartOnlyConfig) 保留原值，重启后生效
+func ReloadConfig() {
+	old := Cfg()
+	cfg, overrides, err := readConfig()
+	if err != nil {
+		log.Println("重新加载失败: config.json 无效:", err)
+		return
+	}
+	if !old.Installed {
+		// 安装模式下检测到已完成安装的配置，切换到博客模式
+		if cfg.Installed {
+			setConfigOverrides(overrides)
+			SetConfig(cfg)
+			RequestRestart()
+		}
+		return
+	}
+	if !cfg.Installed {
+		log.Println("重新加载失败: config.json 不存在或未完成安装")
+		return
+	}
+	settings, err := readSiteSettings()
+	if err != nil {
+		log.Println("重新加载失败: 站点设置读取失败:", err)
+		return
+	}
+	// 保存配置时这些字段同样保留文件中的新值，不会被当前值覆盖
+	if changed := keepRestartOnlyConfig(&cfg, old); len(changed) > 0 {
+		log.Printf("以下配置的修改需要重启后生效: %s", strings.Join(changed, ", "))
+		overrides = append(overrides, changed...)
+	}
+	if cfg.Theme != old.Theme {
+		if err := Themes.Activate(cfg.Theme); err != nil {
+			log.Println("主题加载失败，继续使用当前主题:", err)
+			cfg.Theme = old.Theme
+		}
+	}
+
+	setConfigOverrides(overrides)
+	SetConfig(cfg)
+	siteSettings.Store(&settings)
+	plugins.Init()
+	log.Println("配置已重新加载")
+}
+
+// restartOnlyConfig 只在启动时读取的配置 (json 字段名)：数据库连接、检索引擎、媒体目录、监听与证书、
+// 反向代理、会话 Cookie 与主密钥。重新加载时保留当前值，避免配置与实际使用的连接、目录不一致
+var restartOnlyConfig = []string{
+	"db_type", "db_host", "db_port", "db_user", "db_password", "db_name", "db_dsn", "search_engine", "media_dir",
+	"listen", "http_listen", "tls_cert", "tls_key", "acme_domains", "acme_email", "acme_directory", "acme_ca_cert",
+	"acme_cache_dir", "trusted_proxies", "cookie_secure", "cookie_same_site", "secret_key",
+}
+
+// keepRestartOnlyConfig 把 cfg 中只在启动时读取的配置恢复为 old 的值，返回被修改过的字段
+func keepRestartOnlyConfig(cfg, old *Config) []string {
+	var changed []string
+	v, ov := reflect.ValueOf(cfg).Elem(), reflect.ValueOf(old).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		key := configKey(v.Type().Field(i))
+		if !slices.Contains(restartOnlyConfig, key) {
+			continue
+		}
+		if !reflect.DeepEqual(v.Field(i).Interface(), ov.Field(i).Interface()) {
+			changed = append(changed, key)
+			v.Field(i).Set(ov.Field(i))
+		}
+	}
+	return changed
+}
+
+// registerHealthRoutes 注册存活与就绪检查 (供 Docker / Kubernetes / 负载均衡使用)
+func registerHealthRoutes(app *fiber.App, installed bool) {
+	app.Get("/healthz", func(c *fiber.Ctx) error {
+		return c.JSON(fiber.Map{"status": "ok"})
+	})
+	app.Get("/readyz", func(c *fiber.Ctx) error {
+		switch {
+		case !ready.Load():
+			return c.Status(503).JSON(fiber.Map{"status": "draining"})
+		case !installed:
+			return c.Status(503).JSON(fiber.Map{"status": "not_installed"})
+		}
+		sqlDB, err := DB.DB()
+		if err == nil {
+			ctx, cancel := context.WithTimeout(c.Context(), 2*time.Second)
+			defer cancel()
+			err = sqlDB.PingContext(ctx)
+		}
+		if err != nil {
+			return c.Status(503).JSON(fiber.Map{"status": "database_unavailable", "error": err.Error()})
+		}
+		return c.JSON(fiber.Map{"status": "ok"})
+	})
+}
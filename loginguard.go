@@ -0,0 +1,214 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 登录防护策略：按账号和按 IP 分别统计时间窗口内的失败次数，
+// 失败后按指数退避限制下一次尝试，达到上限后临时锁定。
+// 状态全部由 login_attempts 表推算，不依赖 Redis 等外部存储。
+const (
+	loginWindow         = 30 * time.Minute // 统计失败次数的时间窗口
+	loginLockDuration   = 15 * time.Minute // 达到上限后的锁定时长
+	loginMaxBackoff     = time.Minute      // 单次退避的最长等待
+	loginAccountLimit   = 5                // 单个账号允许的连续失败次数
+	loginIPLimit        = 20               // 单个 IP 允许的失败次数
+	loginIPBackoffAfter = 5                // IP 失败超过该次数后才开始退避
+	loginAttemptKeep    = 90 * 24 * time.Hour
+)
+
+// 登录尝试的结果
+const (
+	LoginOK       = "ok"       // 登录成功
+	LoginPassword = "password" // 用户名或密码错误
+	LoginTOTP     = "2fa"      // 两步验证失败
+	LoginUnlock   = "unlock"   // 管理员解除锁定 (不是真实的登录)
+)
+
+// ErrLoginFailed 统一的登录失败提示，不区分用户名不存在还是密码错误
+const ErrLoginFailed = "用户名或密码错误"
+
+// RecordLoginAttempt 记录一次登录尝试
+func RecordLoginAttempt(username, ip string, success bool, result string) {
+	DB.Create(&LoginAttempt{Username: normalizeLoginName(username), IP: ip, Success: success, Result: result})
+}
+
+func normalizeLoginName(username string) string {
+	username = strings.TrimSpace(username)
+	if len(username) > 100 {
+		username = username[:100]
+	}
+	return username
+}
+
+// loginFailures 统计 col = val 在时间窗口内、最近一次重置之后的失败次数与最后一次失败的时间
+// 账号在登录成功或被解锁时重置；IP 只在被解锁时重置，避免攻击者用自己的账号清零计数
+func loginFailures(col, val string, resetOnSuccess bool) (int64, time.Time) {
+	since := time.Now().Add(-loginWindow)
+	var reset LoginAttempt
+	query := DB.Where(col+" = ? AND created_at > ? AND success = ?", val, since, true)
+	if !resetOnSuccess {
+		query = query.Where("result = ?", LoginUnlock)
+	}
+	if query.Order("id desc").Limit(1).Find(&reset).RowsAffected > 0 {
+		since = reset.CreatedAt
+	}
+
+	var n int64
+	var last LoginAttempt
+	failed := DB.Where(col+" = ? AND created_at > ? AND success = ?", val, since, false)
+	failed.Session(&gorm.Session{}).Model(&LoginAttempt{}).Count(&n)
+	if n > 0 {
+		failed.Order("id desc").Limit(1).Find(&last)
+	}
+	return n, last.CreatedAt
+}
+
+// loginWait 根据失败次数计算还需等待的时间；超过上限时锁定
+func loginWait(failures int64, last time.Time, limit, backoffAfter int64) time.Duration {
+	if failures <= backoffAfter {
+		return 0
+	}
+	var until time.Time
+	if failures >= limit {
+		until = last.Add(loginLockDuration)
+	} else {
+		backoff := time.Duration(math.Pow(2, float64(failures-backoffAfter-1))) * time.Second
+		until = last.Add(min(backoff, loginMaxBackoff))
+	}
+	return time.Until(until)
+}
+
+// keyLocks 按键加锁，同一个键的操作依次执行，没有等待者的键会被移除。只在当前进程内有效
+type keyLocks struct {
+	mu   sync.Mutex
+	keys map[string]*keyLock
+}
+
+type keyLock struct {
+	sync.Mutex
+	refs int
+}
+
+// lock 锁定一个键，返回解锁函数
+func (locks *keyLocks) lock(key string) func() {
+	locks.mu.Lock()
+	if locks.keys == nil {
+		locks.keys = make(map[string]*keyLock)
+	}
+	l := locks.keys[key]
+	if l == nil {
+		l = &keyLock{}
+		locks.keys[key] = l
+	}
+	l.refs++
+	locks.mu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		locks.mu.Lock()
+		if l.refs--; l.refs == 0 {
+			delete(locks.keys, key)
+		}
+		locks.mu.Unlock()
+	}
+}
+
+// loginLocks 同一账号或同一 IP 的登录尝试依次执行 (检查、校验密码、记录结果)，
+// 否则并发的请求会在任何一次失败被记录之前全部通过检查
+var loginLocks keyLocks
+
+// BeginLogin 锁定账号与 IP 后检查是否需要等待，wait 为 0 时可以尝试登录
+// 必须在记录本次尝试的结果之后调用 done
+func BeginLogin(username, ip string) (done func(), wait time.Duration) {
+	// 固定先锁账号再锁 IP，避免互相等待
+	unlockUser := loginLocks.lock("username:" + normalizeLoginName(username))
+	unlockIP := loginLocks.lock("ip:" + ip)
+	done = func() {
+		unlockIP()
+		unlockUser()
+	}
+	return done, LoginThrottle(username, ip)
+}
+
+// LoginThrottle 检查账号与 IP 是否需要等待，返回需要等待的时间 (0 表示可以尝试)
+func LoginThrottle(username, ip string) time.Duration {
+	n, last := loginFailures("username", normalizeLoginName(username), true)
+	wait := loginWait(n, last, loginAccountLimit, 0)
+	n, last = loginFailures("ip", ip, false)
+	if w := loginWait(n, last, loginIPLimit, loginIPBackoffAfter); w > wait {
+		wait = w
+	}
+	return wait
+}
+
+// LoginThrottleMessage 返回需要等待时的提示
+func LoginThrottleMessage(wait time.Duration) string {
+	if wait > loginMaxBackoff {
+		return fmt.Sprintf("尝试次数过多，请 %d 分钟后再试", int(math.Ceil(wait.Minutes())))
+	}
+	return fmt.Sprintf("尝试过于频繁，请 %d 秒后再试", int(math.Ceil(wait.Seconds())))
+}
+
+// UnlockLogin 解除账号或 IP 的锁定 (col 为 username 或 ip)
+func UnlockLogin(col, val string) {
+	a := LoginAttempt{Success: true, Result: LoginUnlock}
+	if col == "ip" {
+		a.IP = val
+	} else {
+		a.Username = val
+	}
+	DB.Create(&a)
+}
+
+// LoginLock 后台展示的锁定项
+type LoginLock struct {
+	Kind     string // username / ip
+	Value    string
+	Failures int64
+	Until    time.Time
+}
+
+// LockedLogins 返回当前处于锁定状态的账号与 IP
+func LockedLogins() []LoginLock {
+	var locks []LoginLock
+	for _, kind := range []struct {
+		col            string
+		limit          int64
+		resetOnSuccess bool
+	}{{"username", loginAccountLimit, true}, {"ip", loginIPLimit, false}} {
+		var values []string
+		DB.Model(&LoginAttempt{}).Where("success = ? AND created_at > ? AND "+kind.col+" <> ''", false, time.Now().Add(-loginWindow)).
+			Group(kind.col).Having("COUNT(*) >= ?", kind.limit).Pluck(kind.col, &values)
+		for _, v := range values {
+			n, last := loginFailures(kind.col, v, kind.resetOnSuccess)
+			if n >= kind.limit && time.Now().Before(last.Add(loginLockDuration)) {
+				locks = append(locks, LoginLock{Kind: kind.col, Value: v, Failures: n, Until: last.Add(loginLockDuration)})
+			}
+		}
+	}
+	return locks
+}
+
+// PruneLoginAttempts 清理过期的登录记录
+func PruneLoginAttempts() {
+	if DB == nil {
+		return
+	}
+	DB.Where("created_at < ?", time.Now().Add(-loginAttemptKeep)).Delete(&LoginAttempt{})
+}
+
+// dummyHash 与 HashPassword 相同代价的 bcrypt 哈希，用于不存在的用户
+const dummyHash = "$2a$14$FiL6H0MUzlUA5QFKx3bRUezk/UdozxEoEsnngQffQ3g3VEvWJJPD."
+
+// checkDummyPassword 用户不存在时也执行一次同样代价的哈希比较，避免通过响应时间判断用户名是否存在
+func checkDummyPassword(password string) {
+	CheckPasswordHash(password, dummyHash)
+}
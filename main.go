@@ -3,23 +3,29 @@ package main
 import (
 	"archive/zip"
 	"bytes"
+	"database/sql"
 	"embed"
 	"encoding/json"
+	"errors"
 	"html/template"
 	"io"
 	"io/fs"
 	"log"
+	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
 	"sort"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"gopress/plugins"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/limiter"
 	"github.com/gofiber/fiber/v2/middleware/session"
-	"github.com/gofiber/template/html/v2"
 	"github.com/yuin/goldmark"
 	"gorm.io/gorm"
 )
@@ -70,56 +76,129 @@ func restoreAssets() {
 
 // 全局变量
 var (
-	shouldRestart      = false
-	store              *session.Store
-	GlobalSiteSettings map[string]string
+	store *session.Store
+	// siteSettings 站点设置，保存或重新加载时整体替换
+	siteSettings atomic.Pointer[map[string]string]
 )
 
+// SiteSettings 返回当前的站点设置 (只读)
+func SiteSettings() map[string]string {
+	if settings := siteSettings.Load(); settings != nil {
+		return *settings
+	}
+	return map[string]string{}
+}
+
 func main() {
 	// 启动时释放资源
+	cmd, install := parseCommandLine(os.Args[1:])
 	restoreAssets()
+	if cmd == "install" {
+		runInstallCommand(install)
+		return
+	}
 
-	for {
-		shouldRestart = false
-		runApp()
-		if !shouldRestart {
-			break
-		}
+	HandleSignals()
+	for runApp() {
 		log.Println("正在重新加载系统...")
-		time.Sleep(500 * time.Millisecond)
 	}
+	log.Println("已退出")
 }
 
 // 加载站点设置
 func LoadSiteSettings() {
+	if settings, err := readSiteSettings(); err == nil {
+		siteSettings.Store(&settings)
+	}
+}
+
+// readSiteSettings 从数据库读取站点设置，不修改当前设置
+func readSiteSettings() (map[string]string, error) {
 	if DB == nil {
-		return
+		return nil, errors.New("数据库未连接")
 	}
 	var options []Option
 	if err := DB.Find(&options).Error; err != nil {
-		return
+		return nil, err
 	}
 	settings := make(map[string]string)
 	settings["site_title"] = "GoPress"
 	settings["site_description"] = "A simple blog."
 	settings["site_url"] = "http://localhost:3000"
 	settings["site_keywords"] = "blog, gopress"
+	settings["feed_fulltext"] = "0"
+	settings["posts_per_page"] = "10"
+	settings["comment_moderation"] = "1"
+	settings["require_2fa_roles"] = ""
 	for _, opt := range options {
 		settings[opt.Name] = opt.Value
 	}
-	GlobalSiteSettings = settings
+	return settings, nil
 }
 
-// 扁平化主题配置
-func FlattenThemeConfig() {
-	CurrentThemeConfig.Config = make(map[string]string)
-	for _, s := range CurrentThemeConfig.Settings {
-		val := s.Value
-		if val == "" {
-			val = s.Default
+// SiteSettingKeys 可在后台与 API 中修改的站点设置
+var SiteSettingKeys = []string{"site_title", "site_description", "site_url", "site_keywords", "feed_fulltext", "posts_per_page", "comment_moderation", "require_2fa_roles"}
+
+// SaveSiteSettings 保存站点设置，忽略未知的设置项并规范取值
+func SaveSiteSettings(values map[string]string) error {
+	for _, k := range SiteSettingKeys {
+		v, ok := values[k]
+		if !ok {
+			continue
+		}
+		switch k {
+		case "posts_per_page":
+			v = strconv.Itoa(ParsePerPage(v))
+		case "feed_fulltext", "comment_moderation":
+			if v != "1" {
+				v = "0"
+			}
+		case "require_2fa_roles":
+			var roles []string
+			for _, r := range strings.Split(v, ",") {
+				if r = strings.TrimSpace(r); IsValidRole(r) {
+					roles = append(roles, r)
+				}
+			}
+			v = strings.Join(roles, ",")
+		}
+		if err := DB.Save(&Option{Name: k, Value: v}).Error; err != nil {
+			return err
 		}
-		CurrentThemeConfig.Config[s.Key] = val
 	}
+	LoadSiteSettings()
+	return nil
+}
+
+// RenderMarkdown 渲染正文 (模板函数 markdown)
+func RenderMarkdown(text string) template.HTML {
+	text = plugins.ApplyFilter("OnMarkdown", text) // Hook
+	var buf bytes.Buffer
+	if err := goldmark.Convert([]byte(text), &buf); err != nil {
+		return template.HTML("")
+	}
+	html := buf.String()
+	html = plugins.ApplyFilter("OnContentRender", html) // Hook
+	return template.HTML(html)
+}
+
+// RenderSummary 渲染摘要 (模板函数 summary)：优先截取 <!--more--> 之前的内容，否则取前 200 字
+func RenderSummary(content string) template.HTML {
+	parts := strings.Split(content, "<!--more-->")
+	if len(parts) > 1 {
+		var buf bytes.Buffer
+		goldmark.Convert([]byte(parts[0]), &buf)
+		return template.HTML(buf.String())
+	}
+	runes := []rune(content)
+	if len(runes) > 200 {
+		var buf bytes.Buffer
+		goldmark.Convert([]byte(string(runes[:200])+"..."), &buf)
+		return template.HTML(buf.String())
+	}
+	var buf bytes.Buffer
+	goldmark.Convert([]byte(content), &buf)
+	return template.HTML(buf.String())
 }
 
 // 解压 ZIP
@@ -157,50 +236,94 @@ func Unzip(src string, dest string) error {
 	return nil
 }
 
-func runApp() {
+// protectedDataPaths 插件不能通过文件权限读写的数据：数据库、证书与私钥
+func protectedDataPaths() []string {
+	cfg := Cfg()
+	var paths []string
+	for _, path := range []string{cfg.TLSCert, cfg.TLSKey, cfg.ACMECacheDir, defaultACMEDir} {
+		if path != "" {
+			paths = append(paths, path)
+		}
+	}
+	if cfg.DBType != "mysql" && cfg.DBType != "postgres" {
+		// 与 ConnectDB 相同的取值顺序；DSN 可能带有 file: 前缀与 ?参数
+		db := cfg.DBDSN
+		if db == "" {
+			db = cfg.DBName
+		}
+		if db == "" {
+			db = "gopress.db"
+		}
+		db, _, _ = strings.Cut(strings.TrimPrefix(db, "file:"), "?")
+		for _, suffix := range []string{"", "-wal", "-shm", "-journal"} {
+			paths = append(paths, db+suffix)
+		}
+	}
+	return paths
+}
+
+// pluginFileAccess 读写数据库服务器上文件的语句与函数，只读事务拦不住它们
+var pluginFileAccess = regexp.MustCompile(`(?i)\binto\s+(outfile|dumpfile)\b|\b(load_file|lo_import|lo_export|pg_read_file|pg_read_binary_file|pg_ls_dir|pg_stat_file)\s*\(`)
+
+// pluginQuery 供声明了 database 权限的插件查询数据：只接受单条 SELECT，
+// 在只读事务中执行 (SQLite 通过 query_only 实现) 并最终回滚，数据修改型的 CTE 等语句会被数据库拒绝
+func pluginQuery(query string, args ...interface{}) ([]map[string]interface{}, error) {
+	query = strings.TrimSuffix(strings.TrimSpace(query), ";")
+	lower := strings.ToLower(query)
+	if !(strings.HasPrefix(lower, "select") || strings.HasPrefix(lower, "with")) || strings.Contains(query, ";") {
+		return nil, errors.New("只允许执行单条 SELECT 查询")
+	}
+	if pluginFileAccess.MatchString(query) {
+		return nil, errors.New("不允许访问数据库服务器上的文件")
+	}
+	tx := DB.Begin(&sql.TxOptions{ReadOnly: true})
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	defer tx.Rollback()
+	if dbType := Cfg().DBType; dbType != "mysql" && dbType != "postgres" {
+		if err := tx.Exec("PRAGMA query_only = ON").Error; err != nil {
+			return nil, err
+		}
+		// 连接会回到连接池，回滚前恢复
+		defer tx.Exec("PRAGMA query_only = OFF")
+	}
+	var rows []map[string]interface{}
+	if err := tx.Raw(query, args...).Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	// MySQL 驱动以 []byte 返回文本，转换后插件才能直接使用
+	for _, row := range rows {
+		for k, v := range row {
+			if b, ok := v.([]byte); ok {
+				row[k] = string(b)
+			}
+		}
+	}
+	return rows, nil
+}
+
+// runApp 创建并运行应用，返回 true 表示需要重新创建 (安装完成后)
+func runApp() bool {
 	isInstalled := LoadConfig()
-	FlattenThemeConfig()
 
 	// 初始化插件系统
 	if isInstalled {
+		plugins.DBQuery = pluginQuery
+		plugins.ProtectedPaths = protectedDataPaths
+		plugins.PrivateCookies = []string{sessionCookieName, csrfCookieName}
+		plugins.Store = dbPluginStorage{}
 		plugins.Init()
 	}
 
-	engine := html.New(".", ".html")
-
-	// 注册模板函数
-	engine.AddFunc("safe", func(content string) template.HTML {
-		return template.HTML(content)
-	})
-
-	engine.AddFunc("markdown", func(text string) template.HTML {
-		text = plugins.ApplyFilter("OnMarkdown", text) // Hook
-		var buf bytes.Buffer
-		if err := goldmark.Convert([]byte(text), &buf); err != nil {
-			return template.HTML("")
-		}
-		html := buf.String()
-		html = plugins.ApplyFilter("OnContentRender", html) // Hook
-		return template.HTML(html)
-	})
-
-	engine.AddFunc("summary", func(content string) template.HTML {
-		parts := strings.Split(content, "<!--more-->")
-		if len(parts) > 1 {
-			var buf bytes.Buffer
-			goldmark.Convert([]byte(parts[0]), &buf)
-			return template.HTML(buf.String())
+	// 加载主题与模板，之后切换主题或修改模板都会原地替换，不再重启应用
+	if err := Themes.Activate(Cfg().Theme); err != nil {
+		log.Println("主题加载失败，使用默认主题:", err)
+		if err := Themes.Activate("default"); err != nil {
+			log.Println("默认主题加载失败:", err)
 		}
-		runes := []rune(content)
-		if len(runes) > 200 {
-			var buf bytes.Buffer
-			goldmark.Convert([]byte(string(runes[:200])+"..."), &buf)
-			return template.HTML(buf.String())
-		}
-		var buf bytes.Buffer
-		goldmark.Convert([]byte(content), &buf)
-		return template.HTML(buf.String())
-	})
+	}
+	Themes.Watch()
 
 	if isInstalled {
 		if err := ConnectDB(); err != nil {
@@ -208,25 +331,34 @@ func runApp() {
 			isInstalled = false
 		} else {
 			LoadSiteSettings()
+			if err := InitSecretKey(); err != nil {
+				log.Println("主密钥初始化失败:", err)
+			}
 		}
 	}
 
-	store = session.New(session.Config{Expiration: 24 * time.Hour, CookieHTTPOnly: true})
-	app := fiber.New(fiber.Config{
-		Views:                 engine,
+	store = NewSessionStore(isInstalled)
+	app := fiber.New(withTrustedProxies(fiber.Config{
+		Views:                 Themes,
 		DisableStartupMessage: true,
 		BodyLimit:             20 * 1024 * 1024,
-	})
+		UnescapePath:          true, // 支持中文 slug
+	}, Cfg().TrustedProxies))
+	app.Use(ClientIPMiddleware)
+	app.Use(HTTPSMiddleware)
+	app.Use(SessionCookies)
+	registerHealthRoutes(app, isInstalled)
 
 	// === 安装模式 ===
 	if !isInstalled {
-		log.Println("运行在安装模式 :3000")
+		log.Println("运行在安装模式")
 		app.Get("/", func(c *fiber.Ctx) error { return c.Redirect("/install") })
 		app.Get("/install", func(c *fiber.Ctx) error { return c.Render("views/install", nil) })
 		app.Post("/do-install", func(c *fiber.Ctx) error {
-			dbType := c.FormValue("db_type")
-			newConfig := Config{DBType: dbType, Theme: "default"}
-			if dbType == "sqlite" {
+			// 保留环境变量与命令行参数提供的配置 (如监听地址)
+			newConfig := *Cfg()
+			newConfig.DBType = c.FormValue("db_type")
+			if newConfig.DBType == "sqlite" {
 				newConfig.DBName = c.FormValue("db_path")
 			} else {
 				newConfig.DBHost = c.FormValue("db_host")
@@ -235,70 +367,235 @@ func runApp() {
 				newConfig.DBPass = c.FormValue("db_pass")
 				newConfig.DBName = c.FormValue("db_name")
 			}
-			GlobalConfig = newConfig
-			if err := ConnectDB(); err != nil {
-				return c.Status(500).JSON(fiber.Map{"error": err.Error()})
-			}
-			// 创建管理员
-			hash, _ := HashPassword(c.FormValue("admin_pass"))
-			DB.Create(&User{Username: c.FormValue("admin_user"), Password: hash, Nickname: c.FormValue("admin_nick")})
-			// 写入设置
-			DB.Save(&Option{Name: "site_title", Value: c.FormValue("site_title")})
-			DB.Save(&Option{Name: "site_description", Value: c.FormValue("site_description")})
-			// 保存配置
-			if err := SaveConfig(newConfig); err != nil {
+			err := Install(newConfig, InstallInput{
+				AdminUser: c.FormValue("admin_user"), AdminPass: c.FormValue("admin_pass"), AdminNick: c.FormValue("admin_nick"),
+				SiteTitle: c.FormValue("site_title"), SiteDescription: c.FormValue("site_description"),
+			})
+			if err != nil {
 				return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 			}
-			shouldRestart = true
-			go func() { time.Sleep(1500 * time.Millisecond); app.Shutdown() }()
+			RequestRestart()
 			return c.JSON(fiber.Map{"status": "ok"})
 		})
 	} else {
 		// === 博客模式 ===
-		log.Println("运行在博客模式 :3000")
-		app.Static("/static", "./themes/"+GlobalConfig.Theme+"/static")
+		log.Println("运行在博客模式")
+
+		// === 插件请求/响应钩子 (OnRequest / OnResponse) ===
+		// 后台、API 与静态资源不经过插件，防止插件把管理员挡在门外
+		app.Use(func(c *fiber.Ctx) error {
+			if strings.HasPrefix(c.Path(), "/admin") || strings.HasPrefix(c.Path(), "/api/") || strings.HasPrefix(c.Path(), "/static") {
+				return c.Next()
+			}
+
+			if res, intercepted := plugins.ApplyRequestFilter(c.OriginalURL()); intercepted {
+				for k, v := range res.Headers {
+					c.Set(k, v)
+				}
+				return c.Status(res.Status).SendString(res.Body)
+			}
+
+			if err := c.Next(); err != nil {
+				return err
+			}
+
+			if plugins.HasHook("OnResponse") && strings.HasPrefix(string(c.Response().Header.ContentType()), "text/html") {
+				html := plugins.ApplyResponseFilter(c.OriginalURL(), string(c.Response().Body()))
+				c.Response().SetBodyString(html)
+			}
+			return nil
+		})
+
+		app.Get("/static/*", ServeThemeStatic)
+		app.Static("/uploads", MediaDir())
+
+		// 定时发布
+		StartScheduler()
 
-		themeDir := "themes/" + GlobalConfig.Theme
-		themeLayout := themeDir + "/layout"
 		adminLayout := "views/admin/layout"
 
-		commonData := func(data fiber.Map) fiber.Map {
-			data["Site"] = GlobalSiteSettings
-			data["Theme"] = CurrentThemeConfig
+		// renderTheme 使用当前主题渲染前台页面，names 为候选模板 (依次回退)
+		renderTheme := func(c *fiber.Ctx, data fiber.Map, names ...string) error {
+			theme := Themes.Current()
+			data["Site"] = SiteSettings()
+			data["Theme"] = theme.Config
 			var navPages []Post
 			if DB != nil {
-				DB.Where("type = ? AND status = ?", "page", "published").Order("id asc").Find(&navPages)
+				DB.Scopes(VisibleScope(c)).Where("type = ?", "page").Order("id asc").Find(&navPages)
 			}
 			data["NavPages"] = navPages
-			return data
+			data["Categories"] = GetCategoryTree()
+			if _, ok := data["Feeds"]; !ok {
+				data["Feeds"] = FeedLinks("")
+			}
+			return c.Render(theme.Template(names...), data, theme.Layout())
 		}
 
 		// --- 前台路由 ---
-		app.Get("/", func(c *fiber.Ctx) error {
+		indexHandler := func(c *fiber.Ctx) error {
+			page := PageParam(c)
+			query, pagination := Paginate(DB.Model(&Post{}).Scopes(VisibleScope(c)).Where("type = ?", "post"), page, PostsPerPage(), PageURL("/"))
+			if page > pagination.TotalPages {
+				return c.Status(404).SendString("Not Found")
+			}
 			var posts []Post
-			DB.Where("type = ?", "post").Order("created_at desc").Find(&posts)
-			return c.Render(themeDir+"/index", commonData(fiber.Map{
-				"Title": GlobalSiteSettings["site_title"], "Posts": posts,
-			}), themeLayout)
-		})
+			query.Preload("Categories").Preload("Tags").Order("published_at desc").Find(&posts)
+			title := SiteSettings()["site_title"]
+			if pagination.Page > 1 {
+				title = "第 " + strconv.Itoa(pagination.Page) + " 页 - " + title
+			}
+			return renderTheme(c, fiber.Map{
+				"Title": title, "Posts": posts, "Pagination": pagination,
+			}, "index")
+		}
+		app.Get("/", indexHandler)
+		app.Get("/page/:n<int>", indexHandler)
 
 		app.Get("/post/:slug", func(c *fiber.Ctx) error {
 			var post Post
-			if err := DB.Where("slug = ? AND type = ?", c.Params("slug"), "post").First(&post).Error; err != nil {
+			if err := DB.Preload("Categories").Preload("Tags").Scopes(VisibleScope(c)).Where("slug = ? AND type = ?", c.Params("slug"), "post").First(&post).Error; err != nil {
+				return c.Status(404).SendString("Not Found")
+			}
+			return renderTheme(c, AddCommentData(c, post, fiber.Map{
+				"Title": post.Title + " - " + SiteSettings()["site_title"], "Post": post,
+			}), "post")
+		})
+
+		// --- 评论提交 ---
+		// 同一 IP 每分钟最多提交 5 条
+		commentLimiter := limiter.New(limiter.Config{
+			Max:        5,
+			Expiration: time.Minute,
+			LimitReached: func(c *fiber.Ctx) error {
+				return c.Redirect("/post/" + url.PathEscape(c.Params("slug")) + "?comment=error&reason=" + url.QueryEscape("评论过于频繁，请稍后再试") + "#comments")
+			},
+		})
+		app.Post("/post/:slug/comment", commentLimiter, func(c *fiber.Ctx) error {
+			var post Post
+			if err := DB.Scopes(VisibleScope(c)).Where("slug = ? AND type = ?", c.Params("slug"), "post").First(&post).Error; err != nil {
+				return c.Status(404).SendString("Not Found")
+			}
+			back := "/post/" + url.PathEscape(post.Slug)
+			// 蜜罐字段对访客不可见，被填写时视为机器人，静默丢弃
+			if c.FormValue("hp_website") != "" {
+				return c.Redirect(back + "?comment=pending#comments")
+			}
+			cm, err := SubmitComment(c, post)
+			if err != nil {
+				return c.Redirect(back + "?comment=error&reason=" + url.QueryEscape(err.Error()) + "#comments")
+			}
+			if cm.Status == CommentApproved {
+				return c.Redirect(back + "?comment=approved#comment-" + strconv.Itoa(int(cm.ID)))
+			}
+			return c.Redirect(back + "?comment=pending#comments")
+		})
+
+		// --- 分类 / 标签归档 ---
+		// 主题没有 archive 模板时回退到 index 模板
+		// query 为筛选条件，prefix 为归档地址 (用于生成分页链接)
+		renderArchive := func(c *fiber.Ctx, archive fiber.Map, query *gorm.DB, prefix string) error {
+			page := PageParam(c)
+			query, pagination := Paginate(query, page, PostsPerPage(), PageURL(prefix))
+			if page > pagination.TotalPages {
 				return c.Status(404).SendString("Not Found")
 			}
-			return c.Render(themeDir+"/post", commonData(fiber.Map{
-				"Title": post.Title + " - " + GlobalSiteSettings["site_title"], "Post": post,
-			}), themeLayout)
+			var posts []Post
+			query.Preload("Categories").Preload("Tags").Order("published_at desc").Find(&posts)
+
+			return renderTheme(c, fiber.Map{
+				"Title":      archive["Name"].(string) + " - " + SiteSettings()["site_title"],
+				"Posts":      posts,
+				"Archive":    archive,
+				"Feeds":      archive["Feeds"],
+				"Pagination": pagination,
+			}, "archive", "index")
+		}
+
+		categoryHandler := func(c *fiber.Ctx) error {
+			var cat Category
+			if err := DB.Where("slug = ?", c.Params("slug")).First(&cat).Error; err != nil {
+				return c.Status(404).SendString("Not Found")
+			}
+			query := DB.Model(&Post{}).Scopes(VisibleScope(c)).
+				Where("type = ? AND id IN (?)", "post", DB.Table("post_categories").Select("post_id").Where("category_id IN ?", CategoryDescendantIDs(cat.ID)))
+			return renderArchive(c, fiber.Map{"Type": "category", "Name": cat.Name, "Slug": cat.Slug, "Description": cat.Description, "Feeds": FeedLinks("/category/" + cat.Slug)}, query, "/category/"+cat.Slug)
+		}
+		app.Get("/category/:slug", categoryHandler)
+		app.Get("/category/:slug/page/:n<int>", categoryHandler)
+
+		tagHandler := func(c *fiber.Ctx) error {
+			var tag Tag
+			if err := DB.Where("slug = ?", c.Params("slug")).First(&tag).Error; err != nil {
+				return c.Status(404).SendString("Not Found")
+			}
+			query := DB.Model(&Post{}).Scopes(VisibleScope(c)).
+				Where("type = ? AND id IN (?)", "post", DB.Table("post_tags").Select("post_id").Where("tag_id = ?", tag.ID))
+			return renderArchive(c, fiber.Map{"Type": "tag", "Name": tag.Name, "Slug": tag.Slug, "Description": "", "Feeds": FeedLinks("/tag/" + tag.Slug)}, query, "/tag/"+tag.Slug)
+		}
+		app.Get("/tag/:slug", tagHandler)
+		app.Get("/tag/:slug/page/:n<int>", tagHandler)
+
+		// --- 站内搜索 ---
+		// 主题没有 search 模板时回退到 index 模板
+		app.Get("/search", func(c *fiber.Ctx) error {
+			q := strings.TrimSpace(c.Query("q"))
+			results, pagination := SearchPosts(q, VisibleScope(c), "", c.QueryInt("page", 1), PostsPerPage(), func(n int) string {
+				return "/search?q=" + url.QueryEscape(q) + "&page=" + strconv.Itoa(n)
+			})
+			posts := make([]Post, 0, len(results))
+			for _, r := range results {
+				posts = append(posts, r.Post)
+			}
+			return renderTheme(c, fiber.Map{
+				"Title":      "搜索: " + q + " - " + SiteSettings()["site_title"],
+				"Query":      q,
+				"Results":    results,
+				"Posts":      posts,
+				"Pagination": pagination,
+			}, "search", "index")
 		})
 
+		// --- 订阅源 (RSS 2.0 / Atom / JSON Feed) ---
+		for suffix, format := range map[string]string{"/feed": "rss", "/atom.xml": "atom", "/feed.json": "json"} {
+			format := format
+			app.Get(suffix, func(c *fiber.Ctx) error {
+				var posts []Post
+				FeedQuery().Find(&posts)
+				return SendFeed(c, format, FeedSource{
+					Title: SiteSettings()["site_title"], Description: SiteSettings()["site_description"], Link: "/", Posts: posts,
+				})
+			})
+			app.Get("/category/:slug"+suffix, func(c *fiber.Ctx) error {
+				var cat Category
+				if err := DB.Where("slug = ?", c.Params("slug")).First(&cat).Error; err != nil {
+					return c.Status(404).SendString("Not Found")
+				}
+				var posts []Post
+				FeedQuery().Where("id IN (?)", DB.Table("post_categories").Select("post_id").Where("category_id IN ?", CategoryDescendantIDs(cat.ID))).Find(&posts)
+				return SendFeed(c, format, FeedSource{
+					Title: cat.Name + " - " + SiteSettings()["site_title"], Description: cat.Description, Link: "/category/" + cat.Slug, Posts: posts,
+				})
+			})
+			app.Get("/tag/:slug"+suffix, func(c *fiber.Ctx) error {
+				var tag Tag
+				if err := DB.Where("slug = ?", c.Params("slug")).First(&tag).Error; err != nil {
+					return c.Status(404).SendString("Not Found")
+				}
+				var posts []Post
+				FeedQuery().Where("id IN (?)", DB.Table("post_tags").Select("post_id").Where("tag_id = ?", tag.ID)).Find(&posts)
+				return SendFeed(c, format, FeedSource{
+					Title: tag.Name + " - " + SiteSettings()["site_title"], Description: SiteSettings()["site_description"], Link: "/tag/" + tag.Slug, Posts: posts,
+				})
+			})
+		}
+
 		// --- Sitemap ---
 		app.Get("/sitemap.xml", func(c *fiber.Ctx) error {
 			c.Set("Content-Type", "application/xml")
 
 			// 1. 获取数据库中的文章/页面
 			var items []Post
-			DB.Where("status = ?", "published").Find(&items)
+			DB.Scopes(PublicScope).Find(&items)
 
 			baseURL := c.Protocol() + "://" + c.Hostname()
 
@@ -331,6 +628,28 @@ func runApp() {
 	</url>`
 			}
 
+			// 3. 分类与标签归档页
+			var categories []Category
+			DB.Find(&categories)
+			for _, cat := range categories {
+				xml += `
+	<url>
+		<loc>` + baseURL + "/category/" + cat.Slug + `</loc>
+		<changefreq>weekly</changefreq>
+		<priority>0.5</priority>
+	</url>`
+			}
+			var tags []Tag
+			DB.Find(&tags)
+			for _, tag := range tags {
+				xml += `
+	<url>
+		<loc>` + baseURL + "/tag/" + tag.Slug + `</loc>
+		<changefreq>weekly</changefreq>
+		<priority>0.4</priority>
+	</url>`
+			}
+
 			// === 遍历插件注册的路由 ===
 			pluginRoutes := plugins.GetActiveRoutes()
 			for _, path := range pluginRoutes {
@@ -348,112 +667,840 @@ func runApp() {
 		})
 
 		// --- 后台路由 ---
-		admin := app.Group("/admin")
+		// 除会话外也接受 API 令牌，供 CI 等脚本调用后台接口
+		admin := app.Group("/admin", TokenAuth)
+		// CSRF 防护覆盖整个后台 (包括登录表单)，模板中通过 .CSRFToken 取得令牌
+		admin.Use(CSRFProtect(), bindCSRFToken)
 		admin.Get("/login", func(c *fiber.Ctx) error {
 			return c.Render("views/admin/login", fiber.Map{"Error": c.Query("error")})
 		})
 		admin.Post("/login", func(c *fiber.Ctx) error {
+			username, password := c.FormValue("username"), c.FormValue("password")
+			done, wait := BeginLogin(username, c.IP())
+			defer done()
+			if wait > 0 {
+				c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(wait.Seconds())+1))
+				return c.Redirect("/admin/login?error=" + url.QueryEscape(LoginThrottleMessage(wait)))
+			}
+			// 用户不存在与密码错误返回同样的提示，耗时也相同
 			user := User{}
-			if err := DB.Where("username = ?", c.FormValue("username")).First(&user).Error; err != nil {
-				return c.Redirect("/admin/login?error=用户不存在")
+			if err := DB.Where("username = ?", username).First(&user).Error; err != nil {
+				checkDummyPassword(password)
+				RecordLoginAttempt(username, c.IP(), false, LoginPassword)
+				return c.Redirect("/admin/login?error=" + url.QueryEscape(ErrLoginFailed))
 			}
-			if !CheckPasswordHash(c.FormValue("password"), user.Password) {
-				return c.Redirect("/admin/login?error=密码错误")
+			if !CheckPasswordHash(password, user.Password) {
+				RecordLoginAttempt(username, c.IP(), false, LoginPassword)
+				return c.Redirect("/admin/login?error=" + url.QueryEscape(ErrLoginFailed))
 			}
 			sess, _ := store.Get(c)
-			sess.Set("user_id", user.ID)
-			sess.Save()
+			remember := c.FormValue("remember") == "1"
+			// 启用了两步验证时先记下待验证的用户，通过第二步后才写入 user_id
+			if user.TOTPEnabled {
+				sess.Set("2fa_user_id", user.ID)
+				sess.Set("2fa_started", time.Now().Unix())
+				sess.Set("2fa_tries", 0)
+				sess.Set("2fa_remember", remember)
+				sess.Save()
+				return c.Redirect("/admin/login/2fa")
+			}
+			RecordLoginAttempt(username, c.IP(), true, LoginOK)
+			if err := StartUserSession(c, sess, user, remember); err != nil {
+				return c.Redirect("/admin/login?error=" + url.QueryEscape("登录失败，请重试"))
+			}
+			return c.Redirect("/admin")
+		})
+		admin.Get("/login/2fa", func(c *fiber.Ctx) error {
+			sess, _ := store.Get(c)
+			if sess.Get("2fa_user_id") == nil {
+				return c.Redirect("/admin/login")
+			}
+			return c.Render("views/admin/login_2fa", fiber.Map{"Error": c.Query("error")})
+		})
+		admin.Post("/login/2fa", func(c *fiber.Ctx) error {
+			sess, _ := store.Get(c)
+			uid, _ := sess.Get("2fa_user_id").(uint)
+			started, _ := sess.Get("2fa_started").(int64)
+			tries, _ := sess.Get("2fa_tries").(int)
+			remember, _ := sess.Get("2fa_remember").(bool)
+			clearPending := func() {
+				for _, key := range []string{"2fa_user_id", "2fa_started", "2fa_tries", "2fa_remember"} {
+					sess.Delete(key)
+				}
+			}
+			reset := func(msg string) error {
+				clearPending()
+				sess.Save()
+				return c.Redirect("/admin/login?error=" + url.QueryEscape(msg))
+			}
+			if uid == 0 || time.Since(time.Unix(started, 0)) > twoFactorLoginTTL {
+				return reset("验证超时，请重新登录")
+			}
+			var user User
+			if err := DB.First(&user, uid).Error; err != nil || !user.TOTPEnabled {
+				return reset("请重新登录")
+			}
+			done, wait := BeginLogin(user.Username, c.IP())
+			defer done()
+			if wait > 0 {
+				return reset(LoginThrottleMessage(wait))
+			}
+			if !VerifySecondFactor(&user, c.FormValue("code")) {
+				RecordLoginAttempt(user.Username, c.IP(), false, LoginTOTP)
+				if tries+1 >= twoFactorMaxTries {
+					return reset("验证码错误次数过多，请重新登录")
+				}
+				sess.Set("2fa_tries", tries+1)
+				sess.Save()
+				return c.Redirect("/admin/login/2fa?error=" + url.QueryEscape("验证码不正确"))
+			}
+			clearPending()
+			RecordLoginAttempt(user.Username, c.IP(), true, LoginOK)
+			if err := StartUserSession(c, sess, user, remember); err != nil {
+				return reset("登录失败，请重试")
+			}
 			return c.Redirect("/admin")
 		})
-		admin.Get("/logout", func(c *fiber.Ctx) error {
+		admin.Post("/logout", func(c *fiber.Ctx) error {
 			sess, _ := store.Get(c)
 			sess.Destroy()
 			return c.Redirect("/admin/login")
 		})
 
-		// 鉴权拦截器
+		// 鉴权拦截器：加载当前用户，已删除的账号视为未登录
 		admin.Use(func(c *fiber.Ctx) error {
-			sess, _ := store.Get(c)
-			if sess.Get("user_id") == nil {
+			var user User
+			uid := currentUserID(c)
+			if uid == 0 {
+				return c.Redirect("/admin/login")
+			}
+			if DB.First(&user, uid).Error != nil {
+				sess, _ := store.Get(c)
+				sess.Destroy()
 				return c.Redirect("/admin/login")
 			}
+			c.Locals("user", user)
+			c.Bind(fiber.Map{"CurrentUser": user})
+
+			// 角色要求两步验证但尚未启用时，只允许访问账号安全页面 (令牌请求不受影响)
+			if TwoFactorRequired(user) && !user.TOTPEnabled && c.Locals("token") == nil && !strings.HasPrefix(c.Path(), "/admin/security") {
+				return c.Redirect("/admin/security")
+			}
 			return c.Next()
 		})
 
+		// 按路径校验权限，文章相关的路由在处理函数中检查归属
+		admin.Use("/settings", RequireCap(CapManageOptions))
+		admin.Use("/plugins", RequireCap(CapManagePlugins))
+		admin.Use("/appearance", RequireCap(CapManageThemes))
+		admin.Use("/users", RequireCap(CapManageUsers))
+		admin.Use("/logins", RequireCap(CapManageUsers))
+		admin.Use("/categories", RequireCap(CapManageCategories))
+		admin.Use("/tags", RequireCap(CapManageCategories))
+		admin.Use("/comments", RequireCap(CapModerateComments))
+		// 账号凭据只能在登录后台后修改
+		admin.Use("/tokens", SessionOnly)
+		admin.Use("/security", SessionOnly)
+		admin.Use("/sessions", SessionOnly)
+		admin.Use("/users/reset-2fa", SessionOnly)
+		admin.Use("/pages", RequireCap(CapEditPages))
+		admin.Use("/posts", RequireCap(CapEditPosts))
+		admin.Use("/write", RequireCap(CapEditPosts))
+		admin.Use("/media", RequireCap(CapEditPosts))
+
 		// 仪表盘
 		admin.Get("/", func(c *fiber.Ctx) error {
 			var count int64
 			DB.Model(&Post{}).Where("type = ?", "post").Count(&count)
-			return c.Render("views/admin/dashboard", fiber.Map{"Title": "仪表盘", "Active": "dashboard", "PostCount": count, "Theme": GlobalConfig}, adminLayout)
+			return c.Render("views/admin/dashboard", fiber.Map{"Title": "仪表盘", "Active": "dashboard", "PostCount": count, "Theme": Cfg()}, adminLayout)
 		})
 
 		// 文章 & 页面管理
+		// 带 ?q= 时按关键词检索 (含草稿等所有状态)
+		adminSearch := func(c *fiber.Ctx, pType string, data fiber.Map) error {
+			q := strings.TrimSpace(c.Query("q"))
+			results, pagination := SearchPosts(q, AuthorScope(c), pType, c.QueryInt("page", 1), adminPerPage, func(n int) string {
+				return c.Path() + "?q=" + url.QueryEscape(q) + "&page=" + strconv.Itoa(n)
+			})
+			posts := make([]Post, 0, len(results))
+			for _, r := range results {
+				posts = append(posts, r.Post)
+			}
+			data["Posts"], data["Results"], data["Pagination"], data["Query"] = posts, results, pagination, q
+			data["Authors"] = UserNames()
+			return c.Render("views/admin/list", data, adminLayout)
+		}
 		admin.Get("/posts", func(c *fiber.Ctx) error {
+			data := fiber.Map{"Title": "文章列表", "Active": "posts", "Type": "post"}
+			if c.Query("q") != "" {
+				return adminSearch(c, "post", data)
+			}
+			// 列表不需要正文，避免加载全部内容
+			query, pagination := Paginate(DB.Model(&Post{}).Scopes(AuthorScope(c)).Where("type = ?", "post"), c.QueryInt("page", 1), adminPerPage, func(n int) string {
+				return "/admin/posts?page=" + strconv.Itoa(n)
+			})
 			var posts []Post
-			DB.Where("type = ?", "post").Order("created_at desc").Find(&posts)
-			return c.Render("views/admin/list", fiber.Map{"Title": "文章列表", "Active": "posts", "Posts": posts, "Type": "post"}, adminLayout)
+			query.Preload("Categories").Omit("content").Order("created_at desc").Find(&posts)
+			data["Posts"], data["Pagination"], data["Authors"] = posts, pagination, UserNames()
+			return c.Render("views/admin/list", data, adminLayout)
 		})
 		admin.Get("/pages", func(c *fiber.Ctx) error {
+			data := fiber.Map{"Title": "独立页面", "Active": "pages", "Type": "page"}
+			if c.Query("q") != "" {
+				return adminSearch(c, "page", data)
+			}
+			query, pagination := Paginate(DB.Model(&Post{}).Where("type = ?", "page"), c.QueryInt("page", 1), adminPerPage, func(n int) string {
+				return "/admin/pages?page=" + strconv.Itoa(n)
+			})
 			var posts []Post
-			DB.Where("type = ?", "page").Order("created_at desc").Find(&posts)
-			return c.Render("views/admin/list", fiber.Map{"Title": "独立页面", "Active": "pages", "Posts": posts, "Type": "page"}, adminLayout)
+			query.Omit("content").Order("created_at desc").Find(&posts)
+			data["Posts"], data["Pagination"], data["Authors"] = posts, pagination, UserNames()
+			return c.Render("views/admin/list", data, adminLayout)
 		})
+		// 编辑器页面，保存失败时带着已填写的内容重新渲染
+		renderEditor := func(c *fiber.Ctx, post Post, errMsg string) error {
+			title, active := "撰写文章", "write"
+			if post.ID != 0 {
+				title, active = "编辑内容", "posts"
+			} else if post.Type == "page" {
+				title = "创建页面"
+			}
+			if post.Type == "page" {
+				active = "pages"
+			}
+			selected := make(map[uint]bool)
+			for _, cat := range post.Categories {
+				selected[cat.ID] = true
+			}
+			// 存在比正式版本更新的自动草稿时提示
+			var autosave PostRevision
+			if post.ID != 0 {
+				DB.Where("post_id = ? AND author_id = ? AND autosave = ? AND updated_at > ?", post.ID, currentUserID(c), true, post.UpdatedAt).First(&autosave)
+			}
+			return c.Render("views/admin/write", fiber.Map{
+				"Title": title, "Active": active, "Post": post, "IsEdit": post.ID != 0, "Statuses": PostStatusLabels,
+				"Categories": GetCategoryTree(), "SelectedCats": selected, "TagInput": TagNames(post.Tags), "Autosave": autosave,
+				"Err": errMsg,
+			}, adminLayout)
+		}
+		// savePostForm 保存编辑器表单，成功后回到列表
+		savePostForm := func(c *fiber.Ctx, post Post) error {
+			if err := SavePost(c, &post, PostInputFromForm(c)); err != nil {
+				if errors.Is(err, ErrForbidden) {
+					return forbidden(c)
+				}
+				// 用提交的内容重新填充编辑器
+				in := PostInputFromForm(c)
+				post.Title, post.Slug, post.Content, post.Status = in.Title, in.Slug, in.Content, in.Status
+				post.Categories, post.Tags = nil, nil
+				for _, id := range FormCategoryIDs(c) {
+					post.Categories = append(post.Categories, Category{Model: gorm.Model{ID: id}})
+				}
+				for _, name := range strings.Split(c.FormValue("tags"), ",") {
+					if name = strings.TrimSpace(name); name != "" {
+						post.Tags = append(post.Tags, Tag{Name: name})
+					}
+				}
+				return renderEditor(c, post, err.Error())
+			}
+			SavePostTaxonomy(c, &post)
+			if post.Type == "page" {
+				return c.Redirect("/admin/pages")
+			}
+			return c.Redirect("/admin/posts")
+		}
 		admin.Get("/write", func(c *fiber.Ctx) error {
 			pType := c.Query("type", "post")
-			title, active := "撰写文章", "write"
-			if pType == "page" {
-				title, active = "创建页面", "pages"
+			if pType == "page" && !can(c, CapEditPages) {
+				return forbidden(c)
 			}
-			return c.Render("views/admin/write", fiber.Map{"Title": title, "Active": active, "Post": Post{Type: pType}, "IsEdit": false}, adminLayout)
+			return renderEditor(c, Post{Type: pType, Status: StatusDraft}, "")
 		})
 		admin.Get("/posts/edit/:id", func(c *fiber.Ctx) error {
+			var post Post
+			if err := DB.Preload("Categories").Preload("Tags").First(&post, c.Params("id")).Error; err != nil {
+				return c.Redirect("/admin/posts")
+			}
+			if !CanEditPost(c, post) {
+				return forbidden(c)
+			}
+			return renderEditor(c, post, "")
+		})
+		// 预览 (草稿、待审核等未公开内容)
+		admin.Get("/posts/preview/:id", func(c *fiber.Ctx) error {
+			var post Post
+			if err := DB.Preload("Categories").Preload("Tags").First(&post, c.Params("id")).Error; err != nil {
+				return c.Status(404).SendString("Not Found")
+			}
+			if !CanEditPost(c, post) {
+				return forbidden(c)
+			}
+			tmpl := "post"
+			if post.Type == "page" {
+				tmpl = "page"
+			}
+			return renderTheme(c, AddCommentData(c, post, fiber.Map{
+				"Title": "[预览] " + post.Title + " - " + SiteSettings()["site_title"], "Post": post,
+			}), tmpl)
+		})
+		admin.Post("/posts", func(c *fiber.Ctx) error {
+			return savePostForm(c, Post{})
+		})
+		admin.Post("/posts/update/:id", func(c *fiber.Ctx) error {
 			var post Post
 			if err := DB.First(&post, c.Params("id")).Error; err != nil {
 				return c.Redirect("/admin/posts")
 			}
+			return savePostForm(c, post)
+		})
+		// 修订历史
+		admin.Get("/posts/revisions/:id", func(c *fiber.Ctx) error {
+			var post Post
+			if err := DB.First(&post, c.Params("id")).Error; err != nil {
+				return c.Redirect("/admin/posts")
+			}
+			if !CanEditPost(c, post) {
+				return forbidden(c)
+			}
+			var revisions []PostRevision
+			DB.Where("post_id = ?", post.ID).Order("created_at desc").Find(&revisions)
+
+			// 默认与最新一条修订对比
+			var selected PostRevision
+			if rid := c.Query("rev"); rid != "" {
+				DB.Where("post_id = ?", post.ID).First(&selected, rid)
+			} else if len(revisions) > 0 {
+				selected = revisions[0]
+			}
+
 			active := "posts"
 			if post.Type == "page" {
 				active = "pages"
 			}
-			return c.Render("views/admin/write", fiber.Map{"Title": "编辑内容", "Active": active, "Post": post, "IsEdit": true}, adminLayout)
+			return c.Render("views/admin/revisions", fiber.Map{
+				"Title": "修订历史", "Active": active, "Post": post, "Revisions": revisions,
+				"Selected": selected, "Authors": UserNames(), "Diff": DiffLines(selected.Content, post.Content),
+			}, adminLayout)
 		})
-		admin.Post("/posts", func(c *fiber.Ctx) error {
-			pType := c.FormValue("type")
-			if pType == "" {
-				pType = "post"
+		admin.Post("/posts/revisions/restore/:rid", func(c *fiber.Ctx) error {
+			var rev PostRevision
+			if err := DB.First(&rev, c.Params("rid")).Error; err != nil {
+				return c.Redirect("/admin/posts")
 			}
-			DB.Create(&Post{Title: c.FormValue("title"), Content: c.FormValue("content"), Slug: c.FormValue("slug"), Status: "published", Type: pType})
-			if pType == "page" {
-				return c.Redirect("/admin/pages")
+			var post Post
+			if err := DB.First(&post, rev.PostID).Error; err != nil {
+				return c.Redirect("/admin/posts")
 			}
-			return c.Redirect("/admin/posts")
+			if !CanEditPost(c, post) {
+				return forbidden(c)
+			}
+			// 恢复前保存当前版本，恢复操作本身也可以撤销
+			SaveRevision(&post)
+			post.Title, post.Slug, post.Content = rev.Title, rev.Slug, rev.Content
+			post.EditorID = currentUserID(c)
+			if err := DB.Save(&post).Error; err != nil {
+				return c.Redirect("/admin/posts/revisions/" + strconv.Itoa(int(post.ID)) + "?rev=" + c.Params("rid"))
+			}
+			if rev.Autosave {
+				DB.Unscoped().Delete(&rev)
+			}
+			return c.Redirect("/admin/posts/edit/" + strconv.Itoa(int(post.ID)))
 		})
-		admin.Post("/posts/update/:id", func(c *fiber.Ctx) error {
+		// 编辑器自动保存 (只写入修订表)
+		admin.Post("/posts/autosave/:id", func(c *fiber.Ctx) error {
 			var post Post
-			if err := DB.First(&post, c.Params("id")).Error; err == nil {
-				post.Title = c.FormValue("title")
-				post.Slug = c.FormValue("slug")
-				post.Content = c.FormValue("content")
-				DB.Save(&post)
+			if err := DB.First(&post, c.Params("id")).Error; err != nil {
+				return c.Status(404).JSON(fiber.Map{"error": "Not found"})
 			}
-			if post.Type == "page" {
-				return c.Redirect("/admin/pages")
+			if !CanEditPost(c, post) {
+				return c.Status(403).JSON(fiber.Map{"error": "权限不足"})
 			}
-			return c.Redirect("/admin/posts")
+			if err := SaveAutosave(post.ID, currentUserID(c), c.FormValue("title"), c.FormValue("slug"), c.FormValue("content")); err != nil {
+				return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+			}
+			return c.JSON(fiber.Map{"status": "ok", "time": time.Now().Format("15:04:05")})
 		})
-		admin.Get("/posts/delete/:id", func(c *fiber.Ctx) error {
+		admin.Post("/posts/delete/:id", func(c *fiber.Ctx) error {
 			var post Post
-			DB.First(&post, c.Params("id"))
-			DB.Delete(&post)
+			if err := DB.First(&post, c.Params("id")).Error; err != nil {
+				return c.Redirect("/admin/posts")
+			}
+			if err := DeletePost(c, post); errors.Is(err, ErrForbidden) {
+				return forbidden(c)
+			}
 			if post.Type == "page" {
 				return c.Redirect("/admin/pages")
 			}
 			return c.Redirect("/admin/posts")
 		})
 
+		// 分类管理
+		admin.Get("/categories", func(c *fiber.Ctx) error {
+			var editing Category
+			if id := c.Query("edit"); id != "" {
+				DB.First(&editing, id)
+			}
+			type Row struct {
+				Category
+				Count int64
+			}
+			var rows []Row
+			for _, cat := range GetCategoryTree() {
+				var count int64
+				DB.Table("post_categories").Where("category_id = ?", cat.ID).Count(&count)
+				rows = append(rows, Row{Category: cat, Count: count})
+			}
+			return c.Render("views/admin/categories", fiber.Map{
+				"Title": "分类管理", "Active": "categories", "Rows": rows, "AllCategories": GetCategoryTree(),
+				"Editing": editing, "Err": c.Query("err"),
+			}, adminLayout)
+		})
+		admin.Post("/categories", func(c *fiber.Ctx) error {
+			name := strings.TrimSpace(c.FormValue("name"))
+			if name == "" {
+				return c.Redirect("/admin/categories?err=名称不能为空")
+			}
+			slug := Slugify(c.FormValue("slug"))
+			if slug == "" {
+				slug = Slugify(name)
+			}
+			parentID, _ := strconv.ParseUint(c.FormValue("parent_id"), 10, 64)
+
+			var cat Category
+			if id := c.FormValue("id"); id != "" && id != "0" {
+				if err := DB.First(&cat, id).Error; err != nil {
+					return c.Redirect("/admin/categories?err=分类不存在")
+				}
+				// 防止把分类挂到自己或自己的子孙下面
+				for _, did := range CategoryDescendantIDs(cat.ID) {
+					if did == uint(parentID) {
+						return c.Redirect("/admin/categories?err=不能选择自身或子分类作为父分类")
+					}
+				}
+			}
+			cat.Name, cat.Slug, cat.Description, cat.ParentID = name, slug, c.FormValue("description"), uint(parentID)
+			if err := DB.Save(&cat).Error; err != nil {
+				return c.Redirect("/admin/categories?err=保存失败，Slug 可能重复")
+			}
+			return c.Redirect("/admin/categories")
+		})
+		admin.Post("/categories/delete/:id", func(c *fiber.Ctx) error {
+			var cat Category
+			if err := DB.First(&cat, c.Params("id")).Error; err == nil {
+				// 子分类上移一级
+				DB.Model(&Category{}).Where("parent_id = ?", cat.ID).Update("parent_id", cat.ParentID)
+				DB.Exec("DELETE FROM post_categories WHERE category_id = ?", cat.ID)
+				DB.Unscoped().Delete(&cat)
+			}
+			return c.Redirect("/admin/categories")
+		})
+
+		// 标签管理
+		admin.Get("/tags", func(c *fiber.Ctx) error {
+			type Row struct {
+				Tag
+				Count int64
+			}
+			var tags []Tag
+			DB.Order("name asc").Find(&tags)
+			var rows []Row
+			for _, tag := range tags {
+				var count int64
+				DB.Table("post_tags").Where("tag_id = ?", tag.ID).Count(&count)
+				rows = append(rows, Row{Tag: tag, Count: count})
+			}
+			return c.Render("views/admin/tags", fiber.Map{"Title": "标签管理", "Active": "tags", "Rows": rows, "Err": c.Query("err")}, adminLayout)
+		})
+		admin.Post("/tags", func(c *fiber.Ctx) error {
+			name := strings.TrimSpace(c.FormValue("name"))
+			slug := Slugify(c.FormValue("slug"))
+			if slug == "" {
+				slug = Slugify(name)
+			}
+			if name == "" || slug == "" {
+				return c.Redirect("/admin/tags?err=名称不能为空")
+			}
+			var tag Tag
+			if id := c.FormValue("id"); id != "" && id != "0" {
+				DB.First(&tag, id)
+			}
+			tag.Name, tag.Slug = name, slug
+			if err := DB.Save(&tag).Error; err != nil {
+				return c.Redirect("/admin/tags?err=保存失败，Slug 可能重复")
+			}
+			return c.Redirect("/admin/tags")
+		})
+		admin.Post("/tags/delete/:id", func(c *fiber.Ctx) error {
+			var tag Tag
+			if err := DB.First(&tag, c.Params("id")).Error; err == nil {
+				DB.Exec("DELETE FROM post_tags WHERE tag_id = ?", tag.ID)
+				DB.Unscoped().Delete(&tag)
+			}
+			return c.Redirect("/admin/tags")
+		})
+
+		// 评论审核
+		admin.Get("/comments", func(c *fiber.Ctx) error {
+			status := c.Query("status", CommentPending)
+			if !IsValidCommentStatus(status) {
+				status = CommentPending
+			}
+			query, pagination := Paginate(DB.Model(&Comment{}).Where("status = ?", status), c.QueryInt("page", 1), adminPerPage, func(n int) string {
+				return "/admin/comments?status=" + status + "&page=" + strconv.Itoa(n)
+			})
+			var comments []Comment
+			query.Order("created_at desc").Find(&comments)
+
+			// 关联的文章标题
+			postIDs := make([]uint, 0, len(comments))
+			for _, cm := range comments {
+				postIDs = append(postIDs, cm.PostID)
+			}
+			var posts []Post
+			DB.Select("id", "title", "slug", "type").Where("id IN ?", postIDs).Find(&posts)
+			postMap := make(map[uint]Post, len(posts))
+			for _, p := range posts {
+				postMap[p.ID] = p
+			}
+
+			counts := make(map[string]int64)
+			for _, s := range CommentStatusLabels {
+				var n int64
+				DB.Model(&Comment{}).Where("status = ?", s.Value).Count(&n)
+				counts[s.Value] = n
+			}
+			return c.Render("views/admin/comments", fiber.Map{
+				"Title": "评论", "Active": "comments", "Comments": comments, "Posts": postMap,
+				"Status": status, "Statuses": CommentStatusLabels, "Counts": counts, "Pagination": pagination,
+			}, adminLayout)
+		})
+		// 单条或批量操作: approve / pending / spam / trash / delete
+		admin.Post("/comments/action", func(c *fiber.Ctx) error {
+			// 行内按钮带 ?id= 与 action，批量操作使用勾选的 ids 与 bulk_action
+			action, ids := c.FormValue("bulk_action"), formValues(c, "ids")
+			if id := c.Query("id"); id != "" {
+				action, ids = c.FormValue("action"), []string{id}
+			}
+			if len(ids) > 0 {
+				switch {
+				case action == "delete":
+					DB.Unscoped().Where("id IN ?", ids).Delete(&Comment{})
+				case action == "approve":
+					DB.Model(&Comment{}).Where("id IN ?", ids).Update("status", CommentApproved)
+				case IsValidCommentStatus(action):
+					DB.Model(&Comment{}).Where("id IN ?", ids).Update("status", action)
+				}
+			}
+			back := c.Get("Referer")
+			if !strings.HasPrefix(back, c.BaseURL()+"/admin/comments") {
+				back = "/admin/comments"
+			}
+			return c.Redirect(back)
+		})
+
+		// 用户管理
+		admin.Get("/users", func(c *fiber.Ctx) error {
+			var editing User
+			if id := c.Query("edit"); id != "" {
+				DB.First(&editing, id)
+			}
+			type Row struct {
+				User
+				PostCount int64
+			}
+			var users []User
+			DB.Order("id asc").Find(&users)
+			rows := make([]Row, 0, len(users))
+			for _, u := range users {
+				var count int64
+				DB.Model(&Post{}).Where("author_id = ?", u.ID).Count(&count)
+				rows = append(rows, Row{User: u, PostCount: count})
+			}
+			return c.Render("views/admin/users", fiber.Map{
+				"Title": "用户管理", "Active": "users", "Rows": rows, "Roles": RoleLabels,
+				"Editing": editing, "Err": c.Query("err"),
+			}, adminLayout)
+		})
+		admin.Post("/users", func(c *fiber.Ctx) error {
+			var user User
+			if id := c.FormValue("id"); id != "" && id != "0" {
+				if err := DB.First(&user, id).Error; err != nil {
+					return c.Redirect("/admin/users?err=用户不存在")
+				}
+			}
+			fail := func(err error) error {
+				back := "/admin/users?err=" + url.QueryEscape(err.Error())
+				if user.ID != 0 {
+					back += "&edit=" + strconv.Itoa(int(user.ID))
+				}
+				return c.Redirect(back)
+			}
+			if c.FormValue("password") != "" {
+				if err := CheckPasswordChange(c, user, c.FormValue("current_password")); errors.Is(err, ErrSessionRequired) {
+					return SessionOnly(c)
+				} else if err != nil {
+					return fail(err)
+				}
+			}
+			if err := SaveUser(&user, c.FormValue("username"), c.FormValue("password"), c.FormValue("nickname"), c.FormValue("role")); err != nil {
+				return fail(err)
+			}
+			if c.FormValue("password") != "" {
+				revokeSessionsAfterReset(c, user)
+			}
+			return c.Redirect("/admin/users")
+		})
+		// 用户丢失手机且没有恢复码时，由管理员重置其两步验证
+		admin.Post("/users/reset-2fa/:id", func(c *fiber.Ctx) error {
+			var user User
+			if err := DB.First(&user, c.Params("id")).Error; err != nil {
+				return c.Redirect("/admin/users")
+			}
+			if err := DisableTwoFactor(&user); err != nil {
+				return c.Redirect("/admin/users?err=" + url.QueryEscape(err.Error()))
+			}
+			return c.Redirect("/admin/users")
+		})
+		admin.Post("/users/delete/:id", func(c *fiber.Ctx) error {
+			var user User
+			if err := DB.First(&user, c.Params("id")).Error; err != nil {
+				return c.Redirect("/admin/users")
+			}
+			if user.ID == currentUserID(c) {
+				return c.Redirect("/admin/users?err=" + url.QueryEscape("不能删除当前登录的账号"))
+			}
+			// 文章转给执行删除的管理员
+			if err := DeleteUser(user, currentUserID(c)); err != nil {
+				return c.Redirect("/admin/users?err=" + url.QueryEscape(err.Error()))
+			}
+			return c.Redirect("/admin/users")
+		})
+
+		// 账号安全：两步验证 (每个用户管理自己的账号)
+		renderSecurity := func(c *fiber.Ctx, data fiber.Map) error {
+			user := currentUser(c)
+			data["Title"], data["Active"] = "账号安全", "security"
+			data["Required"] = TwoFactorRequired(user)
+			if user.TOTPEnabled {
+				data["RecoveryLeft"] = RecoveryCodesLeft(user.ID)
+			} else {
+				// 待绑定的密钥先放在会话中，验证通过后才写入账号
+				sess, _ := store.Get(c)
+				secret, _ := sess.Get("2fa_setup_secret").(string)
+				if secret == "" {
+					var err error
+					if secret, err = NewTOTPSecret(); err != nil {
+						return err
+					}
+					sess.Set("2fa_setup_secret", secret)
+					sess.Save()
+				}
+				qr, err := TOTPQRCode(TOTPURI(secret, user.Username))
+				if err != nil {
+					return err
+				}
+				data["QRCode"] = template.URL(qr)
+				data["Secret"] = FormatSecret(secret)
+			}
+			return c.Render("views/admin/security", data, adminLayout)
+		}
+		admin.Get("/security", func(c *fiber.Ctx) error {
+			return renderSecurity(c, fiber.Map{"Msg": c.Query("msg"), "Err": c.Query("err")})
+		})
+		admin.Post("/security/2fa/enable", func(c *fiber.Ctx) error {
+			user := currentUser(c)
+			sess, _ := store.Get(c)
+			secret, _ := sess.Get("2fa_setup_secret").(string)
+			if user.TOTPEnabled || secret == "" {
+				return c.Redirect("/admin/security")
+			}
+			codes, err := EnableTwoFactor(&user, secret, c.FormValue("code"))
+			if err != nil {
+				return renderSecurity(c, fiber.Map{"Err": err.Error()})
+			}
+			sess.Delete("2fa_setup_secret")
+			sess.Save()
+			c.Locals("user", user)
+			c.Bind(fiber.Map{"CurrentUser": user})
+			return renderSecurity(c, fiber.Map{"Msg": "两步验证已启用", "RecoveryCodes": codes})
+		})
+		admin.Post("/security/2fa/disable", func(c *fiber.Ctx) error {
+			user := currentUser(c)
+			if TwoFactorRequired(user) {
+				return c.Redirect("/admin/security?err=" + url.QueryEscape("你的角色必须启用两步验证"))
+			}
+			if !CheckPasswordHash(c.FormValue("password"), user.Password) {
+				return c.Redirect("/admin/security?err=" + url.QueryEscape("密码错误"))
+			}
+			if err := DisableTwoFactor(&user); err != nil {
+				return c.Redirect("/admin/security?err=" + url.QueryEscape(err.Error()))
+			}
+			return c.Redirect("/admin/security?msg=" + url.QueryEscape("两步验证已关闭"))
+		})
+		admin.Post("/security/2fa/recovery", func(c *fiber.Ctx) error {
+			user := currentUser(c)
+			if !user.TOTPEnabled {
+				return c.Redirect("/admin/security")
+			}
+			if !CheckPasswordHash(c.FormValue("password"), user.Password) {
+				return c.Redirect("/admin/security?err=" + url.QueryEscape("密码错误"))
+			}
+			codes, err := GenerateRecoveryCodes(user.ID)
+			if err != nil {
+				return c.Redirect("/admin/security?err=" + url.QueryEscape(err.Error()))
+			}
+			return renderSecurity(c, fiber.Map{"Msg": "已生成新的恢复码，旧的恢复码已失效", "RecoveryCodes": codes})
+		})
+
+		// API 令牌 (每个用户管理自己的令牌)
+		renderTokens := func(c *fiber.Ctx, newToken, errMsg string) error {
+			var tokens []APIToken
+			DB.Where("user_id = ?", currentUserID(c)).Order("created_at desc").Find(&tokens)
+			return c.Render("views/admin/tokens", fiber.Map{
+				"Title": "API 令牌", "Active": "tokens", "Tokens": tokens, "Scopes": TokenScopeLabels,
+				"NewToken": newToken, "Err": errMsg,
+			}, adminLayout)
+		}
+		admin.Get("/tokens", func(c *fiber.Ctx) error {
+			return renderTokens(c, "", c.Query("err"))
+		})
+		admin.Post("/tokens", func(c *fiber.Ctx) error {
+			days, _ := strconv.Atoi(c.FormValue("expires"))
+			// 明文只显示这一次，直接渲染而不是重定向
+			_, plain, err := CreateAPIToken(currentUserID(c), c.FormValue("name"), formValues(c, "scopes"), time.Duration(days)*24*time.Hour)
+			if err != nil {
+				return renderTokens(c, "", err.Error())
+			}
+			return renderTokens(c, plain, "")
+		})
+		admin.Post("/tokens/revoke/:id", func(c *fiber.Ctx) error {
+			DB.Unscoped().Where("id = ? AND user_id = ?", c.Params("id"), currentUserID(c)).Delete(&APIToken{})
+			return c.Redirect("/admin/tokens")
+		})
+
+		// 登录设备 (管理用户的角色可以查看和注销所有人的会话)
+		admin.Get("/sessions", func(c *fiber.Ctx) error {
+			all := can(c, CapManageUsers)
+			uid := currentUserID(c)
+			if all {
+				uid = 0
+			}
+			users := map[uint]string{}
+			if all {
+				var list []User
+				DB.Select("id", "username").Find(&list)
+				for _, u := range list {
+					users[u.ID] = u.Username
+				}
+			}
+			return c.Render("views/admin/sessions", fiber.Map{
+				"Title": "登录设备", "Active": "sessions", "Sessions": ActiveSessions(uid),
+				"Current": CurrentSessionID(c), "ShowUsers": all, "Users": users,
+			}, adminLayout)
+		})
+		admin.Post("/sessions/revoke/:id", func(c *fiber.Ctx) error {
+			query := DB.Where("id = ?", c.Params("id"))
+			if !can(c, CapManageUsers) {
+				query = query.Where("user_id = ?", currentUserID(c))
+			}
+			query.Delete(&Session{})
+			return c.Redirect("/admin/sessions")
+		})
+		admin.Post("/sessions/revoke-others", func(c *fiber.Ctx) error {
+			RevokeUserSessions(currentUserID(c), CurrentSessionID(c))
+			return c.Redirect("/admin/sessions")
+		})
+
+		// 登录记录与锁定
+		admin.Get("/logins", func(c *fiber.Ctx) error {
+			q := strings.TrimSpace(c.Query("q"))
+			query := DB.Model(&LoginAttempt{})
+			if q != "" {
+				query = query.Where("username = ? OR ip = ?", q, q)
+			}
+			query, pagination := Paginate(query, c.QueryInt("page", 1), adminPerPage, func(n int) string {
+				return "/admin/logins?q=" + url.QueryEscape(q) + "&page=" + strconv.Itoa(n)
+			})
+			var attempts []LoginAttempt
+			query.Order("id desc").Find(&attempts)
+			return c.Render("views/admin/logins", fiber.Map{
+				"Title": "登录记录", "Active": "users", "Attempts": attempts, "Locks": LockedLogins(),
+				"Query": q, "Pagination": pagination,
+			}, adminLayout)
+		})
+		admin.Post("/logins/unlock", func(c *fiber.Ctx) error {
+			if kind := c.FormValue("kind"); kind == "username" || kind == "ip" {
+				UnlockLogin(kind, c.FormValue("value"))
+			}
+			return c.Redirect("/admin/logins")
+		})
+
+		// 媒体库
+		admin.Get("/media", func(c *fiber.Ctx) error {
+			var list []Media
+			DB.Order("created_at desc").Find(&list)
+			return c.Render("views/admin/media", fiber.Map{"Title": "媒体库", "Active": "media", "Media": list}, adminLayout)
+		})
+		admin.Get("/media/json", func(c *fiber.Ctx) error {
+			var list []Media
+			query := DB.Order("created_at desc")
+			if c.Query("type") == "image" {
+				query = query.Where("mime LIKE ?", "image/%")
+			}
+			query.Find(&list)
+			items := make([]fiber.Map, 0, len(list))
+			for _, m := range list {
+				items = append(items, fiber.Map{
+					"id": m.ID, "name": m.Name, "url": m.URL(), "thumb": m.ThumbURL(), "webp": m.WebPURL(),
+					"alt": m.Alt, "width": m.Width, "height": m.Height, "is_image": m.IsImage(),
+				})
+			}
+			return c.JSON(items)
+		})
+		admin.Post("/media/upload", RequireCap(CapUploadFiles), func(c *fiber.Ctx) error {
+			form, err := c.MultipartForm()
+			if err != nil || len(form.File["files"]) == 0 {
+				return c.Status(400).JSON(fiber.Map{"error": "请选择文件"})
+			}
+			var uploaded []fiber.Map
+			for _, file := range form.File["files"] {
+				m, err := SaveUpload(file, currentUserID(c))
+				if err != nil {
+					return c.Status(400).JSON(fiber.Map{"error": file.Filename + ": " + err.Error()})
+				}
+				uploaded = append(uploaded, fiber.Map{"id": m.ID, "name": m.Name, "url": m.URL(), "thumb": m.ThumbURL()})
+			}
+			return c.JSON(fiber.Map{"status": "ok", "files": uploaded})
+		})
+		admin.Post("/media/update/:id", RequireCap(CapUploadFiles), func(c *fiber.Ctx) error {
+			var m Media
+			if err := DB.First(&m, c.Params("id")).Error; err != nil {
+				return c.Status(404).JSON(fiber.Map{"error": "Not found"})
+			}
+			if m.UploaderID != currentUserID(c) && !can(c, CapEditOthersPosts) {
+				return c.Status(403).JSON(fiber.Map{"error": "权限不足"})
+			}
+			m.Alt = c.FormValue("alt")
+			DB.Save(&m)
+			return c.JSON(fiber.Map{"status": "ok"})
+		})
+		admin.Post("/media/delete/:id", RequireCap(CapUploadFiles), func(c *fiber.Ctx) error {
+			var m Media
+			if err := DB.First(&m, c.Params("id")).Error; err != nil {
+				return c.Status(404).JSON(fiber.Map{"error": "Not found"})
+			}
+			if m.UploaderID != currentUserID(c) && !can(c, CapEditOthersPosts) {
+				return c.Status(403).JSON(fiber.Map{"error": "权限不足"})
+			}
+			DeleteMediaFiles(&m)
+			DB.Unscoped().Delete(&m)
+			return c.JSON(fiber.Map{"status": "ok"})
+		})
+
 		// 外观管理
 		admin.Get("/appearance", func(c *fiber.Ctx) error {
 			entries, _ := os.ReadDir("./themes")
+			active := Themes.ID()
 			type Info struct {
 				ID, Name, Author, Version, Desc, Screenshot string
 				IsActive                                    bool
@@ -461,7 +1508,7 @@ func runApp() {
 			var list []Info
 			for _, e := range entries {
 				if e.IsDir() {
-					info := Info{ID: e.Name(), Name: e.Name(), IsActive: e.Name() == GlobalConfig.Theme}
+					info := Info{ID: e.Name(), Name: e.Name(), IsActive: e.Name() == active}
 					raw, _ := os.ReadFile("themes/" + e.Name() + "/config.json")
 					var tmp ThemeConfig
 					json.Unmarshal(raw, &tmp)
@@ -472,7 +1519,7 @@ func runApp() {
 					list = append(list, info)
 				}
 			}
-			return c.Render("views/admin/appearance", fiber.Map{"Title": "网站外观", "Active": "appearance", "Themes": list, "CurrentTheme": GlobalConfig.Theme}, adminLayout)
+			return c.Render("views/admin/appearance", fiber.Map{"Title": "网站外观", "Active": "appearance", "Themes": list, "CurrentTheme": active}, adminLayout)
 		})
 		admin.Get("/appearance/config/:id", func(c *fiber.Ctx) error {
 			content, err := os.ReadFile("themes/" + c.Params("id") + "/config.json")
@@ -493,9 +1540,8 @@ func runApp() {
 			}
 			newJSON, _ := json.MarshalIndent(config, "", "  ")
 			os.WriteFile(configPath, newJSON, 0644)
-			if tid == GlobalConfig.Theme {
-				CurrentThemeConfig = config
-				FlattenThemeConfig()
+			if tid == Themes.ID() {
+				Themes.Reload()
 			}
 			return c.JSON(fiber.Map{"status": "ok", "message": "配置已保存"})
 		})
@@ -507,11 +1553,15 @@ func runApp() {
 			c.SaveFile(file, "./themes/"+file.Filename)
 			Unzip("./themes/"+file.Filename, "./themes")
 			os.Remove("./themes/" + file.Filename)
-			return c.JSON(fiber.Map{"status": "ok"})
+			// 上传的可能是当前主题的新版本，重新加载后立即生效
+			if err := Themes.Reload(); err != nil {
+				return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+			}
+			return c.JSON(fiber.Map{"status": "ok", "message": "主题已安装"})
 		})
 		admin.Post("/appearance/delete", func(c *fiber.Ctx) error {
 			tid := c.FormValue("theme_id")
-			if tid == GlobalConfig.Theme || tid == "default" {
+			if tid == Themes.ID() || tid == "default" {
 				return c.Status(400).JSON(fiber.Map{"error": "无法删除"})
 			}
 			os.RemoveAll("./themes/" + tid)
@@ -519,13 +1569,17 @@ func runApp() {
 		})
 		admin.Post("/appearance/activate", func(c *fiber.Ctx) error {
 			tid := c.FormValue("theme_id")
-			if tid == GlobalConfig.Theme {
+			if tid == Themes.ID() {
 				return c.JSON(fiber.Map{"status": "ok"})
 			}
-			GlobalConfig.Theme = tid
-			SaveConfig(GlobalConfig)
-			shouldRestart = true
-			go func() { time.Sleep(500 * time.Millisecond); app.Shutdown() }()
+			// 原地替换主题，不中断进行中的请求与登录会话
+			if err := Themes.Activate(tid); err != nil {
+				return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+			}
+			cfg := *Cfg()
+			cfg.Theme = Themes.ID()
+			SetConfig(cfg)
+			SaveConfig(cfg)
 			return c.JSON(fiber.Map{"status": "ok"})
 		})
 
@@ -534,7 +1588,11 @@ func runApp() {
 			return c.Render("views/admin/settings", fiber.Map{
 				"Title":  "基本设置",
 				"Active": "settings",
-				"Site":   GlobalSiteSettings,
+				"Site":   SiteSettings(),
+				"Roles":  RoleLabels,
+				"Require2FA": func(role string) bool {
+					return TwoFactorRequired(User{Role: role})
+				},
 				// 传递 flash message (如果有)
 				"Msg": c.Query("msg"),
 				"Err": c.Query("err"),
@@ -543,37 +1601,42 @@ func runApp() {
 
 		// 系统设置 (POST)
 		admin.Post("/settings", func(c *fiber.Ctx) error {
-			// 1. 保存常规设置
-			settings := map[string]string{
-				"site_title":       c.FormValue("site_title"),
-				"site_description": c.FormValue("site_description"),
-				"site_url":         c.FormValue("site_url"),
-				"site_keywords":    c.FormValue("site_keywords"),
-			}
-			for k, v := range settings {
-				DB.Save(&Option{Name: k, Value: v})
-			}
-
-			// 2. 处理密码修改 (如果填了的话)
+			// 修改密码需要登录会话与当前密码，在保存其他设置之前校验
 			newPass := c.FormValue("new_password")
 			if newPass != "" {
-				// 后端简单校验长度，防止绕过前端
+				if err := CheckPasswordChange(c, currentUser(c), c.FormValue("current_password")); errors.Is(err, ErrSessionRequired) {
+					return SessionOnly(c)
+				} else if err != nil {
+					return c.Redirect("/admin/settings?err=" + url.QueryEscape(err.Error()))
+				}
 				if len(newPass) < 8 {
 					return c.Redirect("/admin/settings?err=密码太短")
 				}
+			}
 
+			// 1. 保存常规设置
+			settings := make(map[string]string)
+			for _, k := range SiteSettingKeys {
+				settings[k] = c.FormValue(k)
+			}
+			settings["require_2fa_roles"] = strings.Join(formValues(c, "require_2fa_roles"), ",")
+			if err := SaveSiteSettings(settings); err != nil {
+				return c.Redirect("/admin/settings?err=" + url.QueryEscape(err.Error()))
+			}
+
+			// 2. 处理密码修改 (如果填了的话)
+			if newPass != "" {
 				// 获取当前用户ID
-				sess, _ := store.Get(c)
-				uid := sess.Get("user_id")
+				uid := currentUserID(c)
 
 				hash, _ := HashPassword(newPass)
 				if err := DB.Model(&User{}).Where("id = ?", uid).Update("password", hash).Error; err != nil {
 					return c.Redirect("/admin/settings?err=密码修改失败")
 				}
+				// 修改密码后注销其他设备上的登录
+				RevokeUserSessions(uid, CurrentSessionID(c))
 			}
 
-			LoadSiteSettings()
-
 			msg := "设置已保存"
 			if newPass != "" {
 				msg = "设置已保存，密码已修改"
@@ -588,10 +1651,19 @@ func runApp() {
 			// 排序
 			sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
 
+			// 等待管理员确认权限的插件，在页面顶部列出
+			var pending []string
+			for _, p := range list {
+				if p.Pending {
+					pending = append(pending, p.Name)
+				}
+			}
+
 			return c.Render("views/admin/plugins", fiber.Map{
 				"Title":   "插件管理",
 				"Active":  "plugins",
 				"Plugins": list,
+				"Pending": pending,
 			}, adminLayout)
 		})
 		admin.Post("/plugins/upload", func(c *fiber.Ctx) error {
@@ -599,11 +1671,23 @@ func runApp() {
 			if err != nil {
 				return c.Status(400).JSON(fiber.Map{"error": "Error"})
 			}
+			before := make(map[string]bool)
+			for _, p := range plugins.GetAllPlugins() {
+				before[p.ID] = true
+			}
 			c.SaveFile(file, "./plugins/"+file.Filename)
 			Unzip("./plugins/"+file.Filename, "./plugins")
 			os.Remove("./plugins/" + file.Filename)
 			plugins.Init()
-			return c.JSON(fiber.Map{"status": "ok"})
+
+			// 新安装或申请了新权限的插件，由管理员确认权限后启用
+			var review []fiber.Map
+			for _, p := range plugins.GetAllPlugins() {
+				if !before[p.ID] || p.Pending {
+					review = append(review, fiber.Map{"id": p.ID, "name": p.Name, "permissions": p.Permissions.Describe()})
+				}
+			}
+			return c.JSON(fiber.Map{"status": "ok", "message": "插件已安装", "plugins": review})
 		})
 		admin.Post("/plugins/toggle", func(c *fiber.Ctx) error {
 			id := c.FormValue("id")
@@ -620,6 +1704,21 @@ func runApp() {
 			var meta plugins.PluginMetadata
 			json.Unmarshal(data, &meta)
 			meta.Active = active
+			meta.DisabledReason = ""
+
+			// 启用时记录管理员确认的权限，禁用时撤销，再次启用需要重新确认
+			var err error
+			if active {
+				if err := meta.Permissions.Validate(); err != nil {
+					return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+				}
+				err = plugins.Grant(meta.ID, meta.Permissions)
+			} else {
+				err = plugins.Revoke(meta.ID)
+			}
+			if err != nil {
+				return c.Status(500).JSON(fiber.Map{"error": "授权记录保存失败"})
+			}
 
 			newData, _ := json.MarshalIndent(meta, "", "  ")
 			os.WriteFile(jsonPath, newData, 0644)
@@ -639,6 +1738,10 @@ func runApp() {
 			}
 
 			os.RemoveAll("./plugins/" + instance.Meta.DirName)
+			plugins.Revoke(instance.Meta.ID)
+			if err := plugins.ClearStorage(instance.Meta.ID); err != nil {
+				log.Println("插件数据清理失败:", err)
+			}
 			plugins.Init()
 			return c.JSON(fiber.Map{"status": "ok"})
 		})
@@ -647,18 +1750,30 @@ func runApp() {
 			return c.JSON(fiber.Map{"status": "ok"})
 		})
 
+		// === REST API ===
+		RegisterAPI(app)
+
 		// === 动态路由代理 (支持热重载和插件页面) ===
 		app.All("/*", func(c *fiber.Ctx) error {
 			// 1. 尝试匹配插件路由
-			result, found := plugins.MatchRoute(c.Method(), c.Path())
+			result, res, found, err := plugins.MatchRoute(c)
+			if err != nil {
+				return c.Status(500).SendString("插件执行失败")
+			}
 
 			if found {
+				// 处理函数设置的状态码、响应头、Cookie 与跳转
+				res.Apply(c)
+				if res.Location != "" || result == nil {
+					return nil
+				}
+
 				// 情况 A: 返回 Map (使用主题渲染)
 				if dataMap, ok := result.(map[string]interface{}); ok {
 					if tmplName, ok := dataMap["template"].(string); ok {
 						mockPost := Post{
-							Title: "", Content: "", Slug: c.Path(), Type: "page",
-							Model: gorm.Model{CreatedAt: time.Now()},
+							Title: "", Content: "", Slug: c.Path(), Type: "page", Status: StatusPublished,
+							Model: gorm.Model{CreatedAt: time.Now()}, PublishedAt: time.Now(),
 						}
 						if t, ok := dataMap["title"].(string); ok {
 							mockPost.Title = t
@@ -666,18 +1781,20 @@ func runApp() {
 						if cnt, ok := dataMap["content"].(string); ok {
 							mockPost.Content = cnt
 						}
-						return c.Render(themeDir+"/"+tmplName, commonData(fiber.Map{
-							"Title":      mockPost.Title + " - " + GlobalSiteSettings["site_title"],
+						return renderTheme(c, fiber.Map{
+							"Title":      mockPost.Title + " - " + SiteSettings()["site_title"],
 							"Post":       mockPost,
 							"PluginData": dataMap,
-						}), themeLayout)
+						}, tmplName)
 					}
 				}
 
 				// === 修复点：情况 B: 返回字符串 (Raw HTML) ===
 				if str, ok := result.(string); ok {
-					// 强制设置 Content-Type 为 HTML，否则浏览器会把它当纯文本显示
-					c.Set("Content-Type", "text/html; charset=utf-8")
+					// 默认按 HTML 返回，否则浏览器会把它当纯文本显示
+					if !res.HasHeader(fiber.HeaderContentType) {
+						c.Set("Content-Type", "text/html; charset=utf-8")
+					}
 					return c.SendString(str)
 				}
 
@@ -693,11 +1810,11 @@ func runApp() {
 			var post Post
 			// Slug 匹配 (去掉开头的 /)
 			slug := strings.TrimPrefix(c.Path(), "/")
-			if err := DB.Where("slug = ? AND type = ?", slug, "page").First(&post).Error; err == nil {
-				return c.Render(themeDir+"/page", commonData(fiber.Map{
-					"Title": post.Title + " - " + GlobalSiteSettings["site_title"],
+			if err := DB.Scopes(VisibleScope(c)).Where("slug = ? AND type = ?", slug, "page").First(&post).Error; err == nil {
+				return renderTheme(c, fiber.Map{
+					"Title": post.Title + " - " + SiteSettings()["site_title"],
 					"Post":  post,
-				}), themeLayout)
+				}, "page")
 			}
 
 			// 3. 404
@@ -705,7 +1822,5 @@ func runApp() {
 		})
 	}
 
-	if err := app.Listen(":3000"); err != nil {
-		log.Println(err)
-	}
+	return serveApp(app)
 }
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp" // 注册 WebP 解码器
+)
+
+// 缩略图 / WebP 变体的最大边长
+const (
+	thumbSize  = 300
+	webpMaxDim = 1600
+
+	maxMediaPixels = 40 * 1000 * 1000
+)
+
+// 允许上传的扩展名 (不含 html/svg 等可执行脚本的格式)
+var allowedMediaExts = map[string]bool{
+	".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true, ".ico": true,
+	".pdf": true, ".zip": true, ".txt": true, ".md": true,
+	".mp3": true, ".mp4": true, ".webm": true,
+}
+
+// MediaDir 返回媒体文件存储目录 (config.json 中的 media_dir，默认 uploads)
+func MediaDir() string {
+	if dir := Cfg().MediaDir; dir != "" {
+		return dir
+	}
+	return "uploads"
+}
+
+// URL 返回原图访问地址
+func (m Media) URL() string {
+	return "/uploads/" + m.Path
+}
+
+// ThumbURL 返回缩略图地址，非图片或生成失败时返回原图地址
+func (m Media) ThumbURL() string {
+	if m.ThumbPath == "" {
+		return m.URL()
+	}
+	return "/uploads/" + m.ThumbPath
+}
+
+// WebPURL 返回 WebP 变体地址，没有时为空
+func (m Media) WebPURL() string {
+	if m.WebPPath == "" {
+		return ""
+	}
+	return "/uploads/" + m.WebPPath
+}
+
+// IsImage 是否为图片
+func (m Media) IsImage() bool {
+	return strings.HasPrefix(m.Mime, "image/")
+}
+
+// SaveUpload 保存上传的文件并生成缩略图 / WebP 变体
+func SaveUpload(file *multipart.FileHeader, uploaderID uint) (*Media, error) {
+	ext := strings.ToLower(filepath.Ext(file.Filename))
+	if !allowedMediaExts[ext] {
+		return nil, errors.New("不支持的文件类型: " + ext)
+	}
+
+	src, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(src); err != nil {
+		return nil, err
+	}
+	data := buf.Bytes()
+
+	// 按年月分目录，文件名随机化，避免重名与路径穿越
+	randBytes := make([]byte, 8)
+	rand.Read(randBytes)
+	base := hex.EncodeToString(randBytes)
+	subDir := time.Now().Format("2006/01")
+	if err := os.MkdirAll(filepath.Join(MediaDir(), subDir), 0755); err != nil {
+		return nil, err
+	}
+
+	media := &Media{
+		Name:       filepath.Base(file.Filename),
+		Path:       path.Join(subDir, base+ext),
+		Mime:       http.DetectContentType(data),
+		Size:       int64(len(data)),
+		UploaderID: uploaderID,
+	}
+	if err := os.WriteFile(filepath.Join(MediaDir(), media.Path), data, 0644); err != nil {
+		return nil, err
+	}
+
+	// 像素数过大的图片不生成变体，防止解码时耗尽内存
+	if cfg, _, err := image.DecodeConfig(bytes.NewReader(data)); err == nil {
+		media.Width, media.Height = cfg.Width, cfg.Height
+	}
+	if media.IsImage() && media.Width*media.Height <= maxMediaPixels {
+		if img, format, err := image.Decode(bytes.NewReader(data)); err == nil {
+			// 缩略图沿用原格式 (GIF 转为 PNG)
+			thumb := resizeImage(img, thumbSize)
+			thumbExt := ".jpg"
+			if format != "jpeg" {
+				thumbExt = ".png"
+			}
+			thumbPath := path.Join(subDir, base+"_thumb"+thumbExt)
+			if err := writeImage(filepath.Join(MediaDir(), thumbPath), thumb, thumbExt); err == nil {
+				media.ThumbPath = thumbPath
+			}
+
+			// WebP 为无损编码，照片 (JPEG) 转换后体积反而更大，只为其他格式生成，且比原图大时丢弃
+			if format != "jpeg" {
+				webpPath := path.Join(subDir, base+".webp")
+				webpFile := filepath.Join(MediaDir(), webpPath)
+				if err := writeImage(webpFile, resizeImage(img, webpMaxDim), ".webp"); err == nil {
+					if info, err := os.Stat(webpFile); err == nil && info.Size() < media.Size {
+						media.WebPPath = webpPath
+					} else {
+						os.Remove(webpFile)
+					}
+				}
+			}
+		}
+	}
+
+	if err := DB.Create(media).Error; err != nil {
+		DeleteMediaFiles(media)
+		return nil, err
+	}
+	return media, nil
+}
+
+// DeleteMediaFiles 删除媒体的原图及所有变体
+func DeleteMediaFiles(m *Media) {
+	for _, p := range []string{m.Path, m.ThumbPath, m.WebPPath} {
+		if p != "" {
+			os.Remove(filepath.Join(MediaDir(), p))
+		}
+	}
+}
+
+// resizeImage 等比缩放到最长边不超过 maxDim，原图更小时不放大
+func resizeImage(img image.Image, maxDim int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= maxDim && h <= maxDim {
+		return img
+	}
+	if w >= h {
+		h = h * maxDim / w
+		w = maxDim
+	} else {
+		w = w * maxDim / h
+		h = maxDim
+	}
+	if w < 1 {
+		w = 1
+	}
+	if h < 1 {
+		h = 1
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+	return dst
+}
+
+func writeImage(dst string, img image.Image, ext string) error {
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	switch ext {
+	case ".jpg":
+		err = jpeg.Encode(f, img, &jpeg.Options{Quality: 85})
+	case ".webp":
+		err = EncodeWebP(f, img)
+	default:
+		err = png.Encode(f, img)
+	}
+	if err != nil {
+		os.Remove(dst)
+	}
+	return err
+}
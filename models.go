@@ -1,6 +1,9 @@
 package main
 
 import (
+	"sync/atomic"
+	"time"
+
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
@@ -14,14 +17,51 @@ type Config struct {
 	DBUser    string `json:"db_user"`
 	DBPass    string `json:"db_password"`
 	DBName    string `json:"db_name"`
+	DBDSN     string `json:"db_dsn,omitempty"` // 完整的连接字符串 (如 postgres://...)，配置后忽略上面的连接字段
 	Theme     string `json:"theme"`
+	MediaDir  string `json:"media_dir,omitempty"` // 媒体文件存储目录，默认 uploads
+	// 检索引擎，留空按数据库自动选择，memory 强制使用内存索引
+	SearchEngine string `json:"search_engine,omitempty"`
+
+	// 登录会话
+	SessionHours   int    `json:"session_hours,omitempty"`    // 未勾选「记住我」时的空闲过期时间，默认 24 小时
+	RememberDays   int    `json:"remember_days,omitempty"`    // 勾选「记住我」时的有效期，默认 30 天
+	CookieSecure   bool   `json:"cookie_secure,omitempty"`    // Cookie 只通过 HTTPS 发送
+	CookieSameSite string `json:"cookie_same_site,omitempty"` // Lax (默认) / Strict / None
+
+	// 优雅退出：收到退出信号后先继续处理请求 drain_delay 秒 (让负载均衡发现就绪检查失败)，
+	// 再停止接受新连接并最多等待 drain_timeout 秒 (默认 10) 让进行中的请求完成
+	DrainDelay   int `json:"drain_delay,omitempty"`
+	DrainTimeout int `json:"drain_timeout,omitempty"`
+
+	// 监听与 HTTPS (说明见 server.go)
+	Listen                string   `json:"listen,omitempty"`      // 默认 :3000，unix:/path 监听 Unix socket
+	HTTPListen            string   `json:"http_listen,omitempty"` // 如 :80
+	TLSCert               string   `json:"tls_cert,omitempty"`
+	TLSKey                string   `json:"tls_key,omitempty"`
+	ACMEDomains           []string `json:"acme_domains,omitempty"`
+	ACMEEmail             string   `json:"acme_email,omitempty"`
+	ACMEDirectory         string   `json:"acme_directory,omitempty"`  // ACME 服务地址，默认 Let's Encrypt
+	ACMECACert            string   `json:"acme_ca_cert,omitempty"`    // ACME 服务的 CA 证书 (测试环境)
+	ACMECacheDir          string   `json:"acme_cache_dir,omitempty"`  // 证书缓存目录，默认 certs
+	HTTPSRedirect         bool     `json:"https_redirect,omitempty"`  // HTTP 请求跳转到 HTTPS (含反向代理转发的请求)
+	TrustedProxies        []string `json:"trusted_proxies,omitempty"` // 反向代理的 IP 或网段，只信任来自这些地址的 X-Forwarded-Proto 与 X-Forwarded-For
+	HSTSMaxAge            int      `json:"hsts_max_age,omitempty"`    // HSTS 有效期 (秒)，0 为不发送
+	HSTSIncludeSubdomains bool     `json:"hsts_include_subdomains,omitempty"`
+
+	// 加密两步验证密钥等敏感数据的主密钥 (Base64)，安装时自动生成；丢失后已启用的两步验证需由管理员重置
+	SecretKey string `json:"secret_key,omitempty"`
+
+	// 插件存储配额 (每个插件)，默认 1000 个键、1 MB
+	PluginStorageKeys  int `json:"plugin_storage_keys,omitempty"`
+	PluginStorageBytes int `json:"plugin_storage_bytes,omitempty"`
 }
 
 // ThemeSetting 定义单个配置项
 type ThemeSetting struct {
 	Key         string   `json:"key"`         // 字段名 (如 site_name)
 	Label       string   `json:"label"`       // 显示名 (如 站点名称)
-	Type        string   `json:"type"`        // 类型: text, textarea, radio, select, checkbox
+	Type        string   `json:"type"`        // 类型: text, textarea, radio, select, checkbox, image (可从媒体库选择)
 	Value       string   `json:"value"`       // 当前值
 	Default     string   `json:"default"`     // 默认值
 	Options     []string `json:"options"`     // 选项 (用于 radio/select)，格式如 ["开启", "关闭"]
@@ -45,10 +85,21 @@ type ThemeConfig struct {
 	Config map[string]string `json:"-"`
 }
 
-var (
-	GlobalConfig       Config
-	CurrentThemeConfig ThemeConfig
-)
+// globalConfig 当前生效的配置，SIGHUP 重新加载时整体替换，请求处理中可以并发读取
+var globalConfig atomic.Pointer[Config]
+
+// Cfg 返回当前配置，返回的配置是只读的，修改时复制一份再通过 SetConfig 替换
+func Cfg() *Config {
+	if cfg := globalConfig.Load(); cfg != nil {
+		return cfg
+	}
+	return &Config{}
+}
+
+// SetConfig 替换当前配置
+func SetConfig(cfg Config) {
+	globalConfig.Store(&cfg)
+}
 
 // Option 系统设置表 (Key-Value)
 type Option struct {
@@ -62,8 +113,77 @@ type Post struct {
 	Title   string
 	Slug    string `gorm:"uniqueIndex;size:200"`
 	Content string `gorm:"type:text"`
-	Status  string
+	Status  string `gorm:"index"`                // published, draft, pending, private, scheduled
 	Type    string `gorm:"default:'post';index"` // 'post' or 'page'
+
+	AuthorID    uint      `gorm:"index"` // 作者 (用户 ID)
+	EditorID    uint      // 最后编辑的用户，0 表示作者
+	PublishedAt time.Time `gorm:"index"` // 发布时间 (定时发布时为未来时间)
+
+	Categories []Category `gorm:"many2many:post_categories;"`
+	Tags       []Tag      `gorm:"many2many:post_tags;"`
+}
+
+// Category 分类 (支持多级)
+type Category struct {
+	gorm.Model
+	Name        string
+	Slug        string `gorm:"uniqueIndex;size:200"`
+	Description string `gorm:"type:text"`
+	ParentID    uint   `gorm:"index"` // 0 表示顶级分类
+
+	Depth int `gorm:"-"` // 树形展示时的层级，仅在内存中使用
+}
+
+// Tag 标签
+type Tag struct {
+	gorm.Model
+	Name string
+	Slug string `gorm:"uniqueIndex;size:200"`
+}
+
+// PostRevision 文章修订历史 (每次保存前的旧版本，以及编辑器自动保存的草稿)
+type PostRevision struct {
+	gorm.Model
+	PostID   uint `gorm:"index"`
+	Title    string
+	Slug     string
+	Content  string `gorm:"type:text"`
+	AuthorID uint   // 该版本的最后编辑者 (自动草稿为保存草稿的用户)
+	Autosave bool   `gorm:"index"` // 自动保存的草稿，不影响公开版本
+}
+
+// Media 媒体库文件
+type Media struct {
+	gorm.Model
+	Name       string // 原始文件名
+	Path       string // 相对于媒体目录的存储路径
+	Mime       string
+	Size       int64
+	Width      int
+	Height     int
+	Alt        string
+	ThumbPath  string // 缩略图
+	WebPPath   string // WebP 变体
+	UploaderID uint   `gorm:"index"`
+}
+
+// Comment 评论 (ParentID 为 0 表示顶级评论)
+type Comment struct {
+	gorm.Model
+	PostID      uint `gorm:"index"`
+	ParentID    uint `gorm:"index"`
+	AuthorName  string
+	AuthorEmail string
+	AuthorURL   string
+	Content     string `gorm:"type:text"`
+	IP          string
+	UserAgent   string
+	Status      string `gorm:"index"` // pending / approved / spam / trash
+	UserID      uint   // 登录用户发表时记录用户 ID
+
+	Children []*Comment `gorm:"-"` // 回复，仅在内存中使用
+	Depth    int        `gorm:"-"` // 树形展示时的层级
 }
 
 // User 用户模型
@@ -72,6 +192,65 @@ type User struct {
 	Username string `gorm:"uniqueIndex;size:100"`
 	Password string
 	Nickname string
+	Role     string `gorm:"size:20;index"` // admin / editor / author / contributor
+
+	// 两步验证 (TOTP)
+	TOTPSecret   string `gorm:"size:255"` // Base32 编码的密钥，以主密钥加密后保存 (见 sealSecret)
+	TOTPEnabled  bool
+	TOTPLastStep int64 // 最近一次通过验证的时间步，防止验证码被重复使用
+}
+
+// Session 登录会话，ID 为会话 Cookie 的 SHA-256 摘要
+type Session struct {
+	ID        string `gorm:"primaryKey;size:64"`
+	Data      []byte
+	ExpiresAt time.Time `gorm:"index"`
+	Remember  bool      // 勾选了「记住我」
+	UserID    uint      `gorm:"index"`
+	IP        string    `gorm:"size:64"`
+	UserAgent string    `gorm:"size:500"`
+	CreatedAt time.Time
+	UpdatedAt time.Time // 最后活动时间
+}
+
+// PluginData 插件的键值存储，按插件 ID 隔离
+type PluginData struct {
+	PluginID  string     `gorm:"primaryKey;size:100"`
+	Name      string     `gorm:"primaryKey;size:191"` // 键
+	Value     string     `gorm:"type:text"`
+	ExpiresAt *time.Time `gorm:"index"` // 为空表示永不过期
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// LoginAttempt 登录尝试记录，用于限流、锁定与审计
+type LoginAttempt struct {
+	ID        uint      `gorm:"primarykey"`
+	CreatedAt time.Time `gorm:"index"`
+	Username  string    `gorm:"size:100;index"` // 提交的用户名 (不一定存在)
+	IP        string    `gorm:"size:64;index"`
+	Success   bool
+	Result    string `gorm:"size:20"` // ok / password / 2fa / unlock
+}
+
+// RecoveryCode 两步验证的恢复码，只保存 SHA-256 摘要，每个只能使用一次
+type RecoveryCode struct {
+	gorm.Model
+	UserID   uint   `gorm:"index"`
+	CodeHash string `gorm:"size:64"`
+	UsedAt   *time.Time
+}
+
+// APIToken 个人 API 令牌，只保存 SHA-256 摘要，明文仅在创建时显示一次
+type APIToken struct {
+	gorm.Model
+	UserID     uint   `gorm:"index"`
+	Name       string `gorm:"size:100"`
+	Prefix     string `gorm:"size:16"` // 明文前几位，用于在列表中辨认
+	TokenHash  string `gorm:"uniqueIndex;size:64"`
+	Scopes     string // 逗号分隔: read / write / admin
+	LastUsedAt *time.Time
+	ExpiresAt  *time.Time // 为空表示永不过期
 }
 
 func HashPassword(password string) (string, error) {
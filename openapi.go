@@ -0,0 +1,288 @@
+package main
+
+// openAPISpec /api/v1 的 OpenAPI 3 描述，由 GET /api/v1/openapi.json 返回
+// 修改 api.go 中的路由或字段时请同步更新
+const openAPISpec = `{
+  "openapi": "3.0.3",
+  "info": {
+    "title": "GoPress REST API",
+    "version": "1.0.0",
+    "description": "文章、页面、站点设置与用户的 JSON 接口。鉴权使用后台登录会话或 Bearer 令牌，权限规则与后台一致。"
+  },
+  "servers": [{"url": "/api/v1"}],
+  "components": {
+    "securitySchemes": {
+      "session": {"type": "apiKey", "in": "cookie", "name": "session_id", "description": "后台登录会话；写操作还需在 X-Csrf-Token 请求头中携带后台页面 <meta name=\"csrf-token\"> 中的令牌"},
+      "token": {"type": "http", "scheme": "bearer", "description": "后台「API 令牌」页面生成的个人令牌；GET 需要 read 权限，其余需要 write 权限"}
+    },
+    "parameters": {
+      "id": {"name": "id", "in": "path", "required": true, "schema": {"type": "integer"}},
+      "page": {"name": "page", "in": "query", "schema": {"type": "integer", "minimum": 1, "default": 1}},
+      "per_page": {"name": "per_page", "in": "query", "schema": {"type": "integer", "minimum": 1, "maximum": 100, "default": 10}}
+    },
+    "schemas": {
+      "Error": {
+        "type": "object",
+        "properties": {"error": {"type": "string"}},
+        "required": ["error"]
+      },
+      "Meta": {
+        "type": "object",
+        "properties": {
+          "page": {"type": "integer"},
+          "per_page": {"type": "integer"},
+          "total": {"type": "integer"},
+          "total_pages": {"type": "integer"},
+          "prev": {"type": "string"},
+          "next": {"type": "string"}
+        }
+      },
+      "Term": {
+        "type": "object",
+        "properties": {"id": {"type": "integer"}, "name": {"type": "string"}, "slug": {"type": "string"}}
+      },
+      "Post": {
+        "type": "object",
+        "properties": {
+          "id": {"type": "integer"},
+          "type": {"type": "string", "enum": ["post", "page"]},
+          "title": {"type": "string"},
+          "slug": {"type": "string"},
+          "content": {"type": "string", "description": "Markdown 原文"},
+          "status": {"type": "string", "enum": ["draft", "pending", "published", "scheduled", "private"]},
+          "url": {"type": "string"},
+          "author_id": {"type": "integer"},
+          "published_at": {"type": "string", "format": "date-time"},
+          "created_at": {"type": "string", "format": "date-time"},
+          "updated_at": {"type": "string", "format": "date-time"},
+          "categories": {"type": "array", "items": {"$ref": "#/components/schemas/Term"}},
+          "tags": {"type": "array", "items": {"$ref": "#/components/schemas/Term"}}
+        }
+      },
+      "PostInput": {
+        "type": "object",
+        "description": "更新时省略的字段保持不变",
+        "properties": {
+          "type": {"type": "string", "enum": ["post", "page"], "description": "仅创建时有效"},
+          "title": {"type": "string"},
+          "slug": {"type": "string"},
+          "content": {"type": "string"},
+          "status": {"type": "string", "enum": ["draft", "pending", "published", "scheduled", "private"], "description": "创建时默认为 draft；没有发布权限时会改为 pending"},
+          "published_at": {"type": "string", "format": "date-time"},
+          "categories": {"type": "array", "items": {"type": "integer"}},
+          "tags": {"type": "array", "items": {"type": "string"}}
+        }
+      },
+      "User": {
+        "type": "object",
+        "properties": {
+          "id": {"type": "integer"},
+          "username": {"type": "string"},
+          "nickname": {"type": "string"},
+          "role": {"type": "string", "enum": ["admin", "editor", "author", "contributor"]},
+          "created_at": {"type": "string", "format": "date-time"}
+        }
+      },
+      "UserInput": {
+        "type": "object",
+        "properties": {
+          "username": {"type": "string", "description": "仅创建时有效"},
+          "password": {"type": "string", "minLength": 8, "description": "更新时为空则保留原密码；不接受 API 令牌，只能使用登录会话修改"},
+          "current_password": {"type": "string", "description": "修改自己的密码时需要提供当前密码"},
+          "nickname": {"type": "string"},
+          "role": {"type": "string", "enum": ["admin", "editor", "author", "contributor"]}
+        }
+      }
+    },
+    "responses": {
+      "BadRequest": {"description": "参数错误", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}},
+      "Unauthorized": {"description": "未登录", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}},
+      "Forbidden": {"description": "权限不足", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}},
+      "NotFound": {"description": "不存在", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}},
+      "Unprocessable": {"description": "校验失败", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}}
+    }
+  },
+  "security": [{"token": []}, {"session": []}, {}],
+  "paths": {
+    "/posts": {
+      "get": {
+        "summary": "文章 / 页面列表",
+        "description": "未登录或没有编辑权限时只返回前台可见的内容",
+        "parameters": [
+          {"name": "type", "in": "query", "schema": {"type": "string", "enum": ["post", "page"], "default": "post"}},
+          {"name": "status", "in": "query", "schema": {"type": "string"}},
+          {"name": "author", "in": "query", "schema": {"type": "integer"}},
+          {"name": "category", "in": "query", "description": "分类 slug，包含子分类", "schema": {"type": "string"}},
+          {"name": "tag", "in": "query", "description": "标签 slug", "schema": {"type": "string"}},
+          {"name": "q", "in": "query", "description": "全文搜索", "schema": {"type": "string"}},
+          {"name": "sort", "in": "query", "description": "id / title / created_at / updated_at / published_at，前缀 - 表示倒序", "schema": {"type": "string", "default": "-published_at"}},
+          {"$ref": "#/components/parameters/page"},
+          {"$ref": "#/components/parameters/per_page"}
+        ],
+        "responses": {
+          "200": {"description": "OK", "content": {"application/json": {"schema": {"type": "object", "properties": {
+            "data": {"type": "array", "items": {"$ref": "#/components/schemas/Post"}},
+            "meta": {"$ref": "#/components/schemas/Meta"}
+          }}}}},
+          "400": {"$ref": "#/components/responses/BadRequest"}
+        }
+      },
+      "post": {
+        "summary": "创建文章 / 页面",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/PostInput"}}}},
+        "responses": {
+          "201": {"description": "Created", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Post"}}}},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "403": {"$ref": "#/components/responses/Forbidden"},
+          "422": {"$ref": "#/components/responses/Unprocessable"}
+        }
+      }
+    },
+    "/posts/{id}": {
+      "parameters": [{"$ref": "#/components/parameters/id"}],
+      "get": {
+        "summary": "读取文章 / 页面",
+        "responses": {
+          "200": {"description": "OK", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Post"}}}},
+          "404": {"$ref": "#/components/responses/NotFound"}
+        }
+      },
+      "put": {
+        "summary": "更新文章 / 页面",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/PostInput"}}}},
+        "responses": {
+          "200": {"description": "OK", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Post"}}}},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "403": {"$ref": "#/components/responses/Forbidden"},
+          "404": {"$ref": "#/components/responses/NotFound"},
+          "422": {"$ref": "#/components/responses/Unprocessable"}
+        }
+      },
+      "patch": {
+        "summary": "更新文章 / 页面 (同 PUT)",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/PostInput"}}}},
+        "responses": {
+          "200": {"description": "OK", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Post"}}}},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "403": {"$ref": "#/components/responses/Forbidden"},
+          "404": {"$ref": "#/components/responses/NotFound"},
+          "422": {"$ref": "#/components/responses/Unprocessable"}
+        }
+      },
+      "delete": {
+        "summary": "删除文章 / 页面",
+        "responses": {
+          "204": {"description": "已删除"},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "403": {"$ref": "#/components/responses/Forbidden"},
+          "404": {"$ref": "#/components/responses/NotFound"}
+        }
+      }
+    },
+    "/options": {
+      "get": {
+        "summary": "站点设置",
+        "description": "没有 manage_options 权限时只返回公开的设置项",
+        "responses": {"200": {"description": "OK", "content": {"application/json": {"schema": {"type": "object", "additionalProperties": {"type": "string"}}}}}}
+      },
+      "put": {
+        "summary": "更新站点设置",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"type": "object", "additionalProperties": {"type": "string"}}}}},
+        "responses": {
+          "200": {"description": "OK", "content": {"application/json": {"schema": {"type": "object", "additionalProperties": {"type": "string"}}}}},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "403": {"$ref": "#/components/responses/Forbidden"},
+          "422": {"$ref": "#/components/responses/Unprocessable"}
+        }
+      }
+    },
+    "/options/{name}": {
+      "get": {
+        "summary": "读取单个设置项",
+        "parameters": [{"name": "name", "in": "path", "required": true, "schema": {"type": "string"}}],
+        "responses": {
+          "200": {"description": "OK", "content": {"application/json": {"schema": {"type": "object", "properties": {"name": {"type": "string"}, "value": {"type": "string"}}}}}},
+          "404": {"$ref": "#/components/responses/NotFound"}
+        }
+      }
+    },
+    "/users/me": {
+      "get": {
+        "summary": "当前登录用户及其权限",
+        "responses": {
+          "200": {"description": "OK", "content": {"application/json": {"schema": {"type": "object", "properties": {
+            "user": {"$ref": "#/components/schemas/User"},
+            "capabilities": {"type": "array", "items": {"type": "string"}}
+          }}}}},
+          "401": {"$ref": "#/components/responses/Unauthorized"}
+        }
+      }
+    },
+    "/users": {
+      "get": {
+        "summary": "用户列表 (需要 manage_users)",
+        "parameters": [
+          {"name": "role", "in": "query", "schema": {"type": "string"}},
+          {"name": "q", "in": "query", "description": "按用户名或昵称搜索", "schema": {"type": "string"}},
+          {"name": "sort", "in": "query", "description": "id / username / created_at", "schema": {"type": "string", "default": "id"}},
+          {"$ref": "#/components/parameters/page"},
+          {"$ref": "#/components/parameters/per_page"}
+        ],
+        "responses": {
+          "200": {"description": "OK", "content": {"application/json": {"schema": {"type": "object", "properties": {
+            "data": {"type": "array", "items": {"$ref": "#/components/schemas/User"}},
+            "meta": {"$ref": "#/components/schemas/Meta"}
+          }}}}},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "403": {"$ref": "#/components/responses/Forbidden"}
+        }
+      },
+      "post": {
+        "summary": "创建用户 (需要 manage_users)",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/UserInput"}}}},
+        "responses": {
+          "201": {"description": "Created", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/User"}}}},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "403": {"$ref": "#/components/responses/Forbidden"},
+          "422": {"$ref": "#/components/responses/Unprocessable"}
+        }
+      }
+    },
+    "/users/{id}": {
+      "parameters": [{"$ref": "#/components/parameters/id"}],
+      "get": {
+        "summary": "读取用户 (需要 manage_users)",
+        "responses": {
+          "200": {"description": "OK", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/User"}}}},
+          "404": {"$ref": "#/components/responses/NotFound"}
+        }
+      },
+      "put": {
+        "summary": "更新用户 (需要 manage_users)",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/UserInput"}}}},
+        "responses": {
+          "200": {"description": "OK", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/User"}}}},
+          "404": {"$ref": "#/components/responses/NotFound"},
+          "422": {"$ref": "#/components/responses/Unprocessable"}
+        }
+      },
+      "patch": {
+        "summary": "更新用户 (同 PUT)",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/UserInput"}}}},
+        "responses": {
+          "200": {"description": "OK", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/User"}}}},
+          "404": {"$ref": "#/components/responses/NotFound"},
+          "422": {"$ref": "#/components/responses/Unprocessable"}
+        }
+      },
+      "delete": {
+        "summary": "删除用户，其文章转给当前用户 (需要 manage_users)",
+        "responses": {
+          "204": {"description": "已删除"},
+          "404": {"$ref": "#/components/responses/NotFound"},
+          "422": {"$ref": "#/components/responses/Unprocessable"}
+        }
+      }
+    }
+  }
+}`
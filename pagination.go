@@ -0,0 +1,126 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// 后台列表每页条数
+const adminPerPage = 20
+
+// Pagination 分页信息 (前台主题与后台列表共用)
+type Pagination struct {
+	Page       int
+	PerPage    int
+	Total      int64
+	TotalPages int
+	HasPrev    bool
+	HasNext    bool
+	PrevURL    string
+	NextURL    string
+	Pages      []PageLink // 页码列表，省略的部分 Number 为 0
+}
+
+// PageLink 单个页码链接
+type PageLink struct {
+	Number  int
+	URL     string
+	Current bool
+}
+
+// Offset 返回当前页在查询中的偏移量
+func (p Pagination) Offset() int {
+	return (p.Page - 1) * p.PerPage
+}
+
+// NewPagination 计算分页，urlFor 根据页码生成链接
+func NewPagination(page, perPage int, total int64, urlFor func(int) string) Pagination {
+	if perPage < 1 {
+		perPage = 10
+	}
+	totalPages := int((total + int64(perPage) - 1) / int64(perPage))
+	if totalPages < 1 {
+		totalPages = 1
+	}
+	if page < 1 {
+		page = 1
+	}
+	if page > totalPages {
+		page = totalPages
+	}
+
+	p := Pagination{
+		Page:       page,
+		PerPage:    perPage,
+		Total:      total,
+		TotalPages: totalPages,
+		HasPrev:    page > 1,
+		HasNext:    page < totalPages,
+	}
+	if p.HasPrev {
+		p.PrevURL = urlFor(page - 1)
+	}
+	if p.HasNext {
+		p.NextURL = urlFor(page + 1)
+	}
+
+	// 首尾两页 + 当前页前后两页，其余用省略号
+	for n := 1; n <= totalPages; n++ {
+		if n <= 2 || n > totalPages-2 || (n >= page-2 && n <= page+2) {
+			p.Pages = append(p.Pages, PageLink{Number: n, URL: urlFor(n), Current: n == page})
+		} else if len(p.Pages) > 0 && p.Pages[len(p.Pages)-1].Number != 0 {
+			p.Pages = append(p.Pages, PageLink{})
+		}
+	}
+	return p
+}
+
+// PostsPerPage 前台每页文章数 (设置项 posts_per_page，默认 10)
+func PostsPerPage() int {
+	return ParsePerPage(SiteSettings()["posts_per_page"])
+}
+
+// ParsePerPage 解析每页条数，限制在 1 ~ 100 之间
+func ParsePerPage(s string) int {
+	n, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil || n < 1 {
+		return 10
+	}
+	if n > 100 {
+		return 100
+	}
+	return n
+}
+
+// PageParam 读取当前页码，优先 /page/:n，其次 ?page=
+func PageParam(c *fiber.Ctx) int {
+	if n, err := c.ParamsInt("n"); err == nil && n > 0 {
+		return n
+	}
+	return c.QueryInt("page", 1)
+}
+
+// PageURL 生成 /page/:n 形式的链接，第一页即 prefix 本身
+func PageURL(prefix string) func(int) string {
+	return func(n int) string {
+		if n <= 1 {
+			if prefix == "" {
+				return "/"
+			}
+			return prefix
+		}
+		return strings.TrimSuffix(prefix, "/") + "/page/" + strconv.Itoa(n)
+	}
+}
+
+// Paginate 统计总数并为查询加上 Offset/Limit
+// query 需指定 Model 且只包含筛选条件，排序与预加载请在返回的查询上追加
+func Paginate(query *gorm.DB, page, perPage int, urlFor func(int) string) (*gorm.DB, Pagination) {
+	var total int64
+	query.Session(&gorm.Session{}).Count(&total)
+	p := NewPagination(page, perPage, total, urlFor)
+	return query.Offset(p.Offset()).Limit(p.PerPage), p
+}
@@ -0,0 +1,72 @@
+package plugins
+
+import (
+	"context"
+	"log"
+	"maps"
+	"reflect"
+	"time"
+
+	"github.com/traefik/yaegi/interp"
+)
+
+// goCall 借出解释器调用函数 name，args 根据函数的类型给出参数；函数不存在时返回 ok = false
+func (p *PluginInstance) goCall(name string, timeout time.Duration, args func(fn reflect.Type) ([]interface{}, error)) (out []reflect.Value, ok bool, err error) {
+	i, err := p.goPool.get(timeout)
+	if err != nil {
+		return nil, true, err
+	}
+	v, err := i.Eval(name)
+	if err != nil || v.Kind() != reflect.Func {
+		p.goPool.put(i)
+		return nil, false, nil
+	}
+	in, err := args(v.Type())
+	if err != nil {
+		p.goPool.put(i)
+		return nil, true, err
+	}
+	out, err = p.callGo(i, v, timeout, in...)
+	return out, true, err
+}
+
+// newGoInterp 创建解释器并执行插件代码；register 为 true 时登记代码注册的路由 (只在第一个解释器加载时登记)
+func newGoInterp(p *PluginInstance, src string, register bool) (*interp.Interpreter, error) {
+	i := interp.New(interp.Options{})
+	i.Use(goSymbols()) // 只允许导入白名单中的标准库
+
+	// 注入 Host API
+	api := map[string]reflect.Value{
+		"RegisterRoute": reflect.ValueOf(func(method, path, handler string) {
+			if register {
+				p.registerRoute(method, path, handler)
+			}
+		}),
+		"Log": reflect.ValueOf(func(msg string) {
+			log.Printf("[Go:%s] %s", p.Meta.Name, msg)
+		}),
+		// 返回配置的副本，插件修改后不会影响其他调用
+		"GetConfig": reflect.ValueOf(func() map[string]string {
+			return maps.Clone(p.Config)
+		}),
+		// 路由处理函数的请求与响应类型
+		"Request":  reflect.ValueOf((*Request)(nil)),
+		"Response": reflect.ValueOf((*Response)(nil)),
+	}
+	// 只导出已声明权限对应的函数，未声明时插件编译失败
+	for name, fn := range hostAPI(p) {
+		api[name] = reflect.ValueOf(fn)
+	}
+	for name, fn := range goStorageAPI(pluginStorage{id: p.Meta.ID}) {
+		api[name] = reflect.ValueOf(fn)
+	}
+	i.Use(interp.Exports{"plugin/plugin": api})
+
+	ctx, cancel := context.WithTimeout(context.Background(), loadTimeout)
+	defer cancel()
+	if _, err := i.EvalWithContext(ctx, src); err != nil {
+		return nil, err
+	}
+	register = false // 加载之后调用 RegisterRoute 不再生效
+	return i, nil
+}
@@ -0,0 +1,109 @@
+package plugins
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testGoPlugin = `
+package main
+
+import (
+	"plugin/plugin"
+	"strings"
+	"time"
+)
+
+func OnMarkdown(s string) string {
+	switch s {
+	case "spin":
+		for {
+		}
+	case "sleep":
+		time.Sleep(hookTimeout)
+		return "slept"
+	case "config":
+		c := plugin.GetConfig()
+		c["greeting"] = "changed"
+		return plugin.GetConfig()["greeting"]
+	}
+	return strings.ToUpper(s)
+}
+
+const hookTimeout = 3 * time.Second
+`
+
+func newTestGoPlugin(t *testing.T) *PluginInstance {
+	t.Helper()
+	p := &PluginInstance{
+		Meta:   PluginMetadata{Name: "test", Active: true, PoolSize: 4, Permissions: Permissions{Hooks: []string{"OnMarkdown"}}},
+		Config: map[string]string{"greeting": "hello"},
+		Hooks:  make(map[string]func(string) (string, error)),
+	}
+	loadGo(p, testGoPlugin)
+	if p.Meta.Error != "" {
+		t.Fatal(p.Meta.Error)
+	}
+	return p
+}
+
+func TestGoGetConfigReturnsCopy(t *testing.T) {
+	p := newTestGoPlugin(t)
+	if res, err := p.Hooks["OnMarkdown"]("config"); err != nil || res != "hello" {
+		t.Fatalf("插件修改了共享的配置: %q, %v", res, err)
+	}
+}
+
+// 一个调用超时被中断时，同时进行的其他调用不受影响
+func TestGoTimeoutDoesNotAbortOtherCalls(t *testing.T) {
+	p := newTestGoPlugin(t)
+	hook := p.Hooks["OnMarkdown"]
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if _, err := hook("spin"); !errors.Is(err, errTimeout) {
+			t.Errorf("死循环应当超时: %v", err)
+		}
+	}()
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			time.Sleep(time.Duration(i) * 50 * time.Millisecond)
+			if res, err := hook("abc"); err != nil || res != "ABC" {
+				t.Errorf("调用结果不正确: %q, %v", res, err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// 阻塞在原生函数中的调用超时后仍占用名额，最多占满池，返回后名额恢复
+func TestGoBlockedCallsBoundedByPool(t *testing.T) {
+	p := newTestGoPlugin(t)
+	hook := p.Hooks["OnMarkdown"]
+
+	var wg sync.WaitGroup
+	for i := 0; i < p.goPool.size; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := hook("sleep"); !errors.Is(err, errTimeout) {
+				t.Errorf("阻塞的调用应当超时: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if _, err := hook("abc"); !errors.Is(err, errPoolBusy) {
+		t.Fatalf("名额用完后应返回 errPoolBusy: %v", err)
+	}
+
+	time.Sleep(2 * time.Second) // 等阻塞的调用返回
+	if res, err := hook("abc"); err != nil || res != "ABC" {
+		t.Fatalf("名额恢复后调用失败: %q, %v", res, err)
+	}
+}
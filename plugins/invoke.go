@@ -0,0 +1,177 @@
+package plugins
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"sync/atomic"
+	"time"
+
+	"github.com/dop251/goja"
+	"github.com/traefik/yaegi/interp"
+)
+
+// 插件调用的时限与自动禁用阈值
+const (
+	hookTimeout  = 2 * time.Second  // 钩子 (每个请求可能调用多次)
+	routeTimeout = 10 * time.Second // 插件路由
+	loadTimeout  = 5 * time.Second  // 加载插件时执行顶层代码
+	maxFailures  = 5                // 连续失败达到该次数后自动禁用插件
+)
+
+var errTimeout = errors.New("执行超时")
+
+// pluginHealth 插件的调用失败统计
+type pluginHealth struct {
+	failures    int // 累计失败次数
+	consecutive int // 连续失败次数，调用成功后清零
+	lastError   string
+	reason      string // 自动禁用的原因
+}
+
+// callJS 调用 JS 函数：超时后中断虚拟机，panic 转换为错误
+func callJS(vm *goja.Runtime, fn goja.Callable, timeout time.Duration, args ...interface{}) (res goja.Value, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	timer := time.AfterFunc(timeout, func() { vm.Interrupt(errTimeout) })
+	defer func() {
+		timer.Stop()
+		vm.ClearInterrupt()
+	}()
+	values := make([]goja.Value, len(args))
+	for i, arg := range args {
+		values[i] = vm.ToValue(arg)
+	}
+	return fn(goja.Undefined(), values...)
+}
+
+// callGo 调用借出的解释器 i 中的函数：在独立的 goroutine 中执行，panic 转换为错误，返回后归还解释器
+//
+// 超时后中断解释器中运行的代码，并丢弃该解释器 (Yaegi 的中断作用于整个解释器，每个解释器同时只执行一个调用，
+// 不会波及其他调用)。阻塞在原生函数 (如 time.Sleep、网络读写) 中的代码无法中断，goroutine 要等原生函数返回才退出，
+// 在此之前一直占用池中的名额，因此这样的 goroutine 最多为池的大小，名额用完后新的调用返回 errPoolBusy
+func (p *PluginInstance) callGo(i *interp.Interpreter, fn reflect.Value, timeout time.Duration, args ...interface{}) ([]reflect.Value, error) {
+	type result struct {
+		out []reflect.Value
+		err error
+	}
+	in := make([]reflect.Value, len(args))
+	for n, arg := range args {
+		in[n] = reflect.ValueOf(arg)
+	}
+	var timedOut atomic.Bool
+	done := make(chan result, 1)
+	go func() {
+		var r result
+		defer func() {
+			if v := recover(); v != nil {
+				r.err = fmt.Errorf("panic: %v", v)
+			}
+			// 超时的调用不再有人等待，由这里丢弃解释器、释放名额
+			if !timedOut.CompareAndSwap(false, true) {
+				p.goPool.discard()
+			}
+			done <- r
+		}()
+		r.out = fn.Call(in)
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case r := <-done:
+		p.goPool.put(i)
+		return r.out, r.err
+	case <-timer.C:
+		if !timedOut.CompareAndSwap(false, true) {
+			// 恰好在超时的同时返回
+			r := <-done
+			p.goPool.put(i)
+			return r.out, r.err
+		}
+		stopGo(i)
+		return nil, errTimeout
+	}
+}
+
+// stopGo 中断解释器中正在运行的代码
+// Yaegi 只在 EvalWithContext 的 context 取消时中断，这里用已取消的 context 触发。
+// 传入无法解析的代码，避免 Eval 执行时改写正在运行的调用所用的栈帧
+func stopGo(i *interp.Interpreter) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for n := 0; n < 3; n++ {
+		if _, err := i.EvalWithContext(ctx, ")"); errors.Is(err, context.Canceled) {
+			return
+		}
+	}
+}
+
+// record 记录一次调用的结果，连续失败过多时自动禁用插件
+func (p *PluginInstance) record(name string, err error) {
+	p.healthMu.Lock()
+	if err == nil {
+		p.health.consecutive = 0
+		p.healthMu.Unlock()
+		return
+	}
+	p.health.failures++
+	p.health.consecutive++
+	p.health.lastError = name + ": " + err.Error()
+	tooMany := p.health.consecutive >= maxFailures
+	p.healthMu.Unlock()
+
+	log.Printf("插件 [%s] %s 调用失败: %v", p.Meta.Name, name, err)
+	if tooMany {
+		p.disable(fmt.Sprintf("连续 %d 次调用失败，最近一次: %s: %v", maxFailures, name, err))
+	}
+}
+
+// disable 停用插件并写入 plugin.json，原因显示在插件管理页面，重新启用后恢复
+func (p *PluginInstance) disable(reason string) {
+	if !p.disabled.CompareAndSwap(false, true) {
+		return
+	}
+	p.healthMu.Lock()
+	p.health.reason = reason
+	p.healthMu.Unlock()
+	log.Printf("插件 [%s] 已自动禁用: %s", p.Meta.Name, reason)
+	jsonPath := "./plugins/" + p.Meta.DirName + "/plugin.json"
+	data, err := os.ReadFile(jsonPath)
+	if err != nil {
+		return
+	}
+	var meta PluginMetadata
+	if json.Unmarshal(data, &meta) != nil {
+		return
+	}
+	meta.Active = false
+	meta.DisabledReason = reason
+	if data, err := json.MarshalIndent(meta, "", "  "); err == nil {
+		os.WriteFile(jsonPath, data, 0644)
+	}
+}
+
+// running 判断插件是否在运行 (已启用且未被自动禁用)
+func (p *PluginInstance) running() bool {
+	return p.Meta.Active && !p.disabled.Load()
+}
+
+// status 返回插件元数据与当前的运行状态
+func (p *PluginInstance) status() PluginMetadata {
+	meta := p.Meta
+	p.healthMu.Lock()
+	meta.Failures, meta.LastError = p.health.failures, p.health.lastError
+	if p.disabled.Load() {
+		meta.Active, meta.DisabledReason = false, p.health.reason
+	}
+	p.healthMu.Unlock()
+	return meta
+}
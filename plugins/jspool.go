@@ -0,0 +1,70 @@
+package plugins
+
+import (
+	"log"
+	"maps"
+	"time"
+
+	"github.com/dop251/goja"
+)
+
+// jsCall 借出虚拟机调用全局函数 name；函数不存在时返回 ok = false
+func (p *PluginInstance) jsCall(name string, timeout time.Duration, args ...interface{}) (res interface{}, ok bool, err error) {
+	vm, err := p.js.get(timeout)
+	if err != nil {
+		return nil, true, err
+	}
+	defer p.js.put(vm)
+	fn, ok := goja.AssertFunction(vm.Get(name))
+	if !ok {
+		return nil, false, nil
+	}
+	val, err := callJS(vm, fn, timeout, args...)
+	if err != nil {
+		return nil, true, err
+	}
+	// 在归还虚拟机之前转换为 Go 值，goja.Value 不能在其他 goroutine 中使用
+	if goja.IsUndefined(val) || goja.IsNull(val) {
+		return nil, true, nil
+	}
+	return val.Export(), true, nil
+}
+
+// newJSRuntime 创建虚拟机并执行插件脚本；register 为 true 时登记脚本注册的路由 (只在第一个虚拟机加载时登记，
+// 之后该虚拟机放回池中处理请求，请求中调用 RegisterRoute 不再生效)
+func newJSRuntime(p *PluginInstance, src string, register bool) (*goja.Runtime, error) {
+	vm := goja.New()
+	// Go 对象的字段在 JS 中使用 json 标签的名称，方法名首字母小写，如 req.params、res.setHeader
+	vm.SetFieldNameMapper(goja.TagFieldNameMapper("json", true))
+
+	// 每个虚拟机使用独立的配置副本，脚本修改配置不会影响其他虚拟机
+	vm.Set("PluginConfig", maps.Clone(p.Config))
+	vm.Set("console", map[string]interface{}{
+		"log": func(call goja.FunctionCall) goja.Value {
+			log.Printf("[JS:%s] %s", p.Meta.Name, call.Argument(0).String())
+			return goja.Undefined()
+		},
+	})
+	vm.Set("RegisterRoute", func(call goja.FunctionCall) goja.Value {
+		if register {
+			p.registerRoute(call.Argument(0).String(), call.Argument(1).String(), call.Argument(2).String())
+		}
+		return goja.Undefined()
+	})
+	// 只注入已声明权限对应的 Host API
+	for name, fn := range hostAPI(p) {
+		vm.Set(name, fn)
+	}
+	vm.Set("Storage", pluginStorage{id: p.Meta.ID})
+
+	// 加载脚本同样有时限，避免死循环卡住插件重载
+	timer := time.AfterFunc(loadTimeout, func() { vm.Interrupt(errTimeout) })
+	_, err := vm.RunString(src)
+	timer.Stop()
+	vm.ClearInterrupt()
+	if err != nil {
+		return nil, err
+	}
+	register = false
+	return vm, nil
+}
@@ -0,0 +1,217 @@
+package plugins
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const testJSPlugin = `
+RegisterRoute("GET", "/hello/:name", "hello");
+RegisterRoute("GET", "/late", "late");
+
+var calls = 0;
+
+function hello(req, res) {
+	calls++;
+	res.setHeader("X-Greeting", PluginConfig.greeting);
+	return PluginConfig.greeting + " " + req.params.name;
+}
+
+// 请求中注册路由不应生效
+function late(req, res) {
+	RegisterRoute("GET", "/hello/late", "hello");
+	return "late";
+}
+
+function spin() {
+	while (true) {}
+}
+
+function OnMarkdown(s) {
+	return s.toUpperCase();
+}
+`
+
+// newTestJSPlugin 加载测试插件并注册为运行中的插件，测试结束后移除
+func newTestJSPlugin(t *testing.T, poolSize int) *PluginInstance {
+	t.Helper()
+	p := &PluginInstance{
+		Meta: PluginMetadata{
+			ID: "jstest", Name: "jstest", Active: true, PoolSize: poolSize,
+			Permissions: Permissions{Routes: []string{"/hello/*", "/late"}, Hooks: []string{"OnMarkdown"}},
+		},
+		Config: map[string]string{"greeting": "hi"},
+		Hooks:  make(map[string]func(string) (string, error)),
+	}
+	loadJS(p, testJSPlugin)
+	if p.Meta.Error != "" {
+		t.Fatal(p.Meta.Error)
+	}
+
+	mu.Lock()
+	old := Instances
+	Instances = map[string]*PluginInstance{p.Meta.ID: p}
+	mu.Unlock()
+	t.Cleanup(func() {
+		mu.Lock()
+		Instances = old
+		mu.Unlock()
+	})
+	return p
+}
+
+// newTestApp 与主程序相同，把未匹配的请求交给插件路由
+func newTestApp() *fiber.App {
+	app := fiber.New()
+	app.All("/*", func(c *fiber.Ctx) error {
+		result, res, found, err := MatchRoute(c)
+		if err != nil {
+			return c.Status(500).SendString(err.Error())
+		}
+		if !found {
+			return c.SendStatus(404)
+		}
+		res.Apply(c)
+		return c.SendString(fmt.Sprint(result))
+	})
+	return app
+}
+
+func TestJSConcurrentFiltersAndRoutes(t *testing.T) {
+	p := newTestJSPlugin(t, 4)
+	app := newTestApp()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			in := fmt.Sprintf("post %d", i)
+			if out := ApplyFilter("OnMarkdown", in); out != strings.ToUpper(in) {
+				t.Errorf("钩子结果不正确: %q", out)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			name := fmt.Sprintf("user%d", i)
+			res, err := app.Test(httptest.NewRequest("GET", "/hello/"+name, nil), -1)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			body, _ := io.ReadAll(res.Body)
+			if res.StatusCode != 200 || string(body) != "hi "+name || res.Header.Get("X-Greeting") != "hi" {
+				t.Errorf("路由结果不正确: %d %q", res.StatusCode, body)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if p.js.created > p.js.size {
+		t.Fatalf("创建了 %d 个虚拟机，超过上限 %d", p.js.created, p.js.size)
+	}
+	if status := p.status(); status.Failures != 0 {
+		t.Fatalf("出现失败的调用: %s", status.LastError)
+	}
+}
+
+// 钩子返回空字符串时替换为空内容，调用失败时保留原内容
+func TestApplyFilterResult(t *testing.T) {
+	p := newTestJSPlugin(t, 1)
+	p.Hooks["OnContentRender"] = func(string) (string, error) { return "", nil }
+	p.Hooks["OnMarkdown"] = func(string) (string, error) { return "", errors.New("boom") }
+
+	if out := ApplyFilter("OnContentRender", "body"); out != "" {
+		t.Fatalf("空返回值应替换原内容: %q", out)
+	}
+	if out := ApplyFilter("OnMarkdown", "body"); out != "body" {
+		t.Fatalf("调用失败时应保留原内容: %q", out)
+	}
+	if out := ApplyFilter("OnUnknown", "body"); out != "body" {
+		t.Fatalf("未注册的钩子不应修改内容: %q", out)
+	}
+}
+
+// 加载后注册的路由不生效，也不会与匹配路由的请求同时修改 Routes
+func TestJSRegisterRouteOnlyDuringLoad(t *testing.T) {
+	p := newTestJSPlugin(t, 4)
+	app := newTestApp()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if _, err := app.Test(httptest.NewRequest("GET", "/late", nil), -1); err != nil {
+				t.Error(err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if _, err := app.Test(httptest.NewRequest("GET", "/hello/x", nil), -1); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(p.Routes) != 2 {
+		t.Fatalf("请求中注册了路由: %+v", p.Routes)
+	}
+}
+
+func TestJSPoolExhausted(t *testing.T) {
+	p := newTestJSPlugin(t, 1)
+
+	// 唯一的虚拟机被占用时，等待超时后返回 errPoolBusy
+	vm, err := p.js.get(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := p.jsCall("hello", 50*time.Millisecond, &Request{}, &Response{Headers: map[string]string{}}); !errors.Is(err, errPoolBusy) {
+		t.Fatalf("虚拟机用完时应返回 errPoolBusy: %v", err)
+	}
+
+	// 归还后等待中的调用可以继续
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := p.jsCall("OnMarkdown", time.Second, "x")
+		done <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	p.js.put(vm)
+	if err := <-done; err != nil {
+		t.Fatalf("归还虚拟机后调用失败: %v", err)
+	}
+}
+
+func TestJSTimeout(t *testing.T) {
+	p := newTestJSPlugin(t, 2)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, _, err := p.jsCall("spin", 100*time.Millisecond); err == nil || !strings.Contains(err.Error(), errTimeout.Error()) {
+				t.Errorf("死循环应当超时: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// 被中断的虚拟机归还后仍可使用
+	for i := 0; i < 4; i++ {
+		if res, _, err := p.jsCall("OnMarkdown", time.Second, "ok"); err != nil || res != "OK" {
+			t.Fatalf("超时后虚拟机不可用: %v, %v", res, err)
+		}
+	}
+}
@@ -2,17 +2,18 @@ package plugins
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
 	"os"
 	"path/filepath"
 	"reflect"
 	"strings"
 	"sync"
+	"sync/atomic"
 
 	"github.com/dop251/goja"
 	"github.com/gofiber/fiber/v2"
 	"github.com/traefik/yaegi/interp"
-	"github.com/traefik/yaegi/stdlib"
 )
 
 // === 结构体定义 ===
@@ -36,7 +37,15 @@ type PluginMetadata struct {
 	Entry       string          `json:"entry"`
 	Active      bool            `json:"active"`
 	Settings    []PluginSetting `json:"settings"`
+	Permissions Permissions     `json:"permissions"`
+	PoolSize    int             `json:"pool_size,omitempty"` // 虚拟机 (Go 插件为解释器) 数量上限，默认 4
 	DirName     string          `json:"-"`
+	Pending     bool            `json:"-"` // 已启用但所需权限超出授权，未运行
+	Error       string          `json:"-"` // 加载失败的原因
+	// 连续调用失败后被自动禁用的原因，重新启用时清除
+	DisabledReason string `json:"disabled_reason,omitempty"`
+	Failures       int    `json:"-"` // 本次加载以来的调用失败次数
+	LastError      string `json:"-"`
 }
 
 type RouteDef struct {
@@ -49,9 +58,13 @@ type PluginInstance struct {
 	Meta   PluginMetadata
 	Config map[string]string // 用户配置
 	Routes []RouteDef
-	Hooks  map[string]func(string) string
-	JsVM   *goja.Runtime
-	GoInt  *interp.Interpreter
+	Hooks  map[string]func(string) (string, error)
+	js     *vmPool[*goja.Runtime]       // JS 插件的虚拟机池
+	goPool *vmPool[*interp.Interpreter] // Go 插件的解释器池
+
+	healthMu sync.Mutex
+	health   pluginHealth
+	disabled atomic.Bool // 连续失败后被自动禁用
 }
 
 var (
@@ -59,15 +72,22 @@ var (
 	Instances = make(map[string]*PluginInstance)
 )
 
+// 插件可以注册的钩子
+var hookNames = []string{"OnContentRender", "OnMarkdown", "OnRequest", "OnResponse", "OnCommentSubmit"}
+
 // === 初始化逻辑 ===
 
 func Init() {
 	newInstances := make(map[string]*PluginInstance)
 	entries, err := os.ReadDir("./plugins")
+	grants := loadGrants()
+	if _, statErr := os.Stat(grantsFile); os.IsNotExist(statErr) && err == nil {
+		grants = migrateGrants(entries)
+	}
 	if err == nil {
 		for _, entry := range entries {
 			if entry.IsDir() {
-				if instance := loadOne(entry.Name()); instance != nil {
+				if instance := loadOne(entry.Name(), grants); instance != nil {
 					newInstances[instance.Meta.ID] = instance
 				}
 			}
@@ -79,7 +99,32 @@ func Init() {
 	log.Printf("插件系统重载完成，加载插件数: %d", len(newInstances))
 }
 
-func loadOne(dirName string) *PluginInstance {
+// migrateGrants 为引入授权记录之前已启用的插件补记授权，升级后这些插件按声明的权限继续运行
+// 只在授权文件不存在时执行一次；权限声明无效的插件不会被授权
+func migrateGrants(entries []os.DirEntry) map[string]Permissions {
+	grants := make(map[string]Permissions)
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join("plugins", entry.Name(), "plugin.json"))
+		if err != nil {
+			continue
+		}
+		var meta PluginMetadata
+		if json.Unmarshal(data, &meta) != nil || !meta.Active || meta.Permissions.Validate() != nil {
+			continue
+		}
+		grants[meta.ID] = meta.Permissions
+		log.Printf("插件 [%s] 在升级前已启用，按声明的权限补记授权", meta.Name)
+	}
+	if err := saveGrants(grants); err != nil {
+		log.Println("保存插件授权失败:", err)
+	}
+	return grants
+}
+
+func loadOne(dirName string, grants map[string]Permissions) *PluginInstance {
 	basePath := "./plugins/" + dirName
 	data, err := os.ReadFile(basePath + "/plugin.json")
 	if err != nil {
@@ -105,17 +150,30 @@ func loadOne(dirName string) *PluginInstance {
 	instance := &PluginInstance{
 		Meta:   meta,
 		Config: configMap,
-		Hooks:  make(map[string]func(string) string),
+		Hooks:  make(map[string]func(string) (string, error)),
 		Routes: []RouteDef{},
 	}
 
 	if !meta.Active {
 		return instance
 	}
+	if err := meta.Permissions.Validate(); err != nil {
+		instance.Meta.Active = false
+		instance.Meta.Error = err.Error()
+		return instance
+	}
+	// 声明的权限超出管理员授予的范围 (如插件更新后申请了新权限) 时拒绝加载，需重新启用并确认
+	if granted, ok := grants[meta.ID]; !ok || !meta.Permissions.Within(granted) {
+		instance.Meta.Active = false
+		instance.Meta.Pending = true
+		log.Printf("插件 [%s] 的权限未经授权，已跳过加载", meta.Name)
+		return instance
+	}
 
 	entryPath := filepath.Join(basePath, meta.Entry)
 	code, err := os.ReadFile(entryPath)
 	if err != nil {
+		instance.Meta.Error = "入口文件读取失败"
 		return instance
 	}
 
@@ -132,86 +190,229 @@ func loadOne(dirName string) *PluginInstance {
 }
 
 // === 钩子调用 (OnContentRender / OnMarkdown) ===
+// 插件的返回值 (包括空字符串) 替换原内容，调用失败时保留原内容
 func ApplyFilter(hookName string, content string) string {
+	for _, p := range running() {
+		hook, exists := p.Hooks[hookName]
+		if !exists {
+			continue
+		}
+		res, err := hook(content)
+		p.record(hookName, err)
+		if err == nil {
+			content = res
+		}
+	}
+	return content
+}
+
+// running 返回正在运行的插件；调用插件时不持有全局锁，插件执行缓慢时不会阻塞重载
+func running() []*PluginInstance {
 	mu.RLock()
 	defer mu.RUnlock()
+	list := make([]*PluginInstance, 0, len(Instances))
 	for _, p := range Instances {
-		if p.Meta.Active && p.Hooks != nil {
-			if hook, exists := p.Hooks[hookName]; exists {
-				content = hook(content)
+		if p.running() {
+			list = append(list, p)
+		}
+	}
+	return list
+}
+
+// runHook 调用插件的钩子并记录结果，未注册或调用失败时返回空字符串 (视为不处理)
+func (p *PluginInstance) runHook(hookName, in string) string {
+	hook, exists := p.Hooks[hookName]
+	if !exists {
+		return ""
+	}
+	res, err := hook(in)
+	p.record(hookName, err)
+	if err != nil {
+		return ""
+	}
+	return res
+}
+
+// HasHook 判断是否有已启用的插件注册了指定钩子
+func HasHook(hookName string) bool {
+	for _, p := range running() {
+		if _, exists := p.Hooks[hookName]; exists {
+			return true
+		}
+	}
+	return false
+}
+
+// HookResponse OnRequest 钩子拦截请求时返回的响应
+// 插件可直接返回 HTML 字符串，也可返回 JSON: {"status": 302, "headers": {"Location": "/"}, "body": ""}
+type HookResponse struct {
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers"`
+	Body    string            `json:"body"`
+}
+
+// parseHookResponse 解析 OnRequest 的返回值
+func parseHookResponse(res string) *HookResponse {
+	trimmed := strings.TrimSpace(res)
+	if strings.HasPrefix(trimmed, "{") {
+		var hr HookResponse
+		if err := json.Unmarshal([]byte(trimmed), &hr); err == nil && (hr.Status != 0 || hr.Body != "" || len(hr.Headers) > 0) {
+			if hr.Status == 0 {
+				hr.Status = 200
 			}
+			return &hr
 		}
 	}
-	return content
+	return &HookResponse{
+		Status:  200,
+		Headers: map[string]string{"Content-Type": "text/html; charset=utf-8"},
+		Body:    res,
+	}
 }
 
 // === 请求生命周期钩子 (OnRequest) ===
 // 返回 (响应内容, 是否拦截)
-func ApplyRequestFilter(url string) (string, bool) {
-	mu.RLock()
-	defer mu.RUnlock()
-	for _, p := range Instances {
-		if p.Meta.Active && p.Hooks != nil {
-			if hook, exists := p.Hooks["OnRequest"]; exists {
-				res := hook(url)
-				if res != "" {
-					return res, true
-				}
-			}
+func ApplyRequestFilter(url string) (*HookResponse, bool) {
+	for _, p := range running() {
+		if res := p.runHook("OnRequest", url); res != "" {
+			return parseHookResponse(res), true
 		}
 	}
-	return "", false
+	return nil, false
 }
 
 // === 响应生命周期钩子 (OnResponse) ===
-// 将 url 和 html 序列化为 JSON 传给插件
-func ApplyResponseFilter(url string, html string) {
-	mu.RLock()
-	defer mu.RUnlock()
+// 将 url 和 html 序列化为 JSON 传给插件，插件返回非空字符串时替换 html
+func ApplyResponseFilter(url string, html string) string {
+	for _, p := range running() {
+		if _, exists := p.Hooks["OnResponse"]; exists {
+			payload, _ := json.Marshal(map[string]string{"url": url, "html": html})
+			if res := p.runHook("OnResponse", string(payload)); res != "" {
+				html = res
+			}
+		}
+	}
+	return html
+}
 
-	payload, _ := json.Marshal(map[string]string{"url": url, "html": html})
-	strPayload := string(payload)
+// CommentData 传给 OnCommentSubmit 钩子的评论内容
+type CommentData struct {
+	PostID    uint   `json:"post_id"`
+	ParentID  uint   `json:"parent_id"`
+	Author    string `json:"author"`
+	Email     string `json:"email"`
+	URL       string `json:"url"`
+	Content   string `json:"content"`
+	IP        string `json:"ip"`
+	UserAgent string `json:"user_agent"`
+	Status    string `json:"status"`
+}
 
-	for _, p := range Instances {
-		if p.Meta.Active && p.Hooks != nil {
-			if hook, exists := p.Hooks["OnResponse"]; exists {
-				hook(strPayload)
+// === 评论提交钩子 (OnCommentSubmit) ===
+// 插件收到评论 JSON，返回空字符串表示放行；返回 JSON 可修改评论或拒绝:
+// {"reject": true, "reason": "..."} 拒绝提交；{"status": "spam"} 标记为垃圾评论；{"content": "..."} 修改内容
+// 返回 (拒绝原因, 是否拒绝)
+func ApplyCommentFilter(data *CommentData) (string, bool) {
+	for _, p := range running() {
+		if _, exists := p.Hooks["OnCommentSubmit"]; exists {
+			payload, _ := json.Marshal(data)
+			res := strings.TrimSpace(p.runHook("OnCommentSubmit", string(payload)))
+			if res == "" {
+				continue
+			}
+			var verdict struct {
+				Reject  bool    `json:"reject"`
+				Reason  string  `json:"reason"`
+				Author  *string `json:"author"`
+				Email   *string `json:"email"`
+				URL     *string `json:"url"`
+				Content *string `json:"content"`
+				Status  *string `json:"status"`
+			}
+			if err := json.Unmarshal([]byte(res), &verdict); err != nil {
+				log.Printf("OnCommentSubmit [%s] 返回值无效: %v", p.Meta.Name, err)
+				continue
+			}
+			if verdict.Reject {
+				if verdict.Reason == "" {
+					verdict.Reason = "评论被拒绝"
+				}
+				return verdict.Reason, true
+			}
+			// 只允许修改以下字段，文章 ID、IP 等保持不变
+			for _, f := range []struct {
+				dst *string
+				src *string
+			}{{&data.Author, verdict.Author}, {&data.Email, verdict.Email}, {&data.URL, verdict.URL}, {&data.Content, verdict.Content}, {&data.Status, verdict.Status}} {
+				if f.src != nil {
+					*f.dst = *f.src
+				}
 			}
 		}
 	}
+	return "", false
 }
 
 // === 路由匹配 ===
-func MatchRoute(method, path string) (interface{}, bool) {
-	mu.RLock()
-	defer mu.RUnlock()
-	for _, p := range Instances {
-		if !p.Meta.Active {
-			continue
-		}
-		for _, r := range p.Routes {
-			if strings.EqualFold(r.Method, method) && r.Path == path {
-				if p.JsVM != nil {
-					val := p.JsVM.Get(r.HandlerName)
-					if fn, ok := goja.AssertFunction(val); ok {
-						if res, err := fn(goja.Undefined(), p.JsVM.ToValue(path)); err == nil {
-							return res.Export(), true
-						}
-					}
+// 按 Fiber 的语法匹配插件路由 (静态路由优先于带参数的路由)，调用处理函数
+// 返回 (处理结果, 处理函数设置的响应, 是否匹配, 处理函数执行失败的错误)
+func MatchRoute(c *fiber.Ctx) (interface{}, *Response, bool, error) {
+	method := c.Method()
+	if method == fiber.MethodHead {
+		method = fiber.MethodGet
+	}
+	plugins := running()
+	for _, dynamic := range []bool{false, true} {
+		for _, p := range plugins {
+			for _, r := range p.Routes {
+				if !strings.EqualFold(r.Method, method) || isDynamicPath(r.Path) != dynamic {
+					continue
 				}
-				if p.GoInt != nil {
-					v, err := p.GoInt.Eval(r.HandlerName)
-					if err == nil && v.Kind() == reflect.Func {
-						res := v.Call([]reflect.Value{reflect.ValueOf(path)})
-						if len(res) > 0 {
-							return res[0].Interface(), true
-						}
-					}
+				params, ok := matchPath(r.Path, c.Path())
+				if !ok {
+					continue
 				}
+				req := newRequest(c, params)
+				res := &Response{Headers: make(map[string]string)}
+				result, err := p.callRoute(r, req, res)
+				p.record("路由 "+r.Path, err)
+				return result, res, true, err
 			}
 		}
 	}
-	return nil, false
+	return nil, nil, false, nil
+}
+
+// callRoute 调用插件的路由处理函数
+// Go 插件的处理函数可以是 func(*plugin.Request, *plugin.Response) T，也可以是只接收路径的 func(string) T
+func (p *PluginInstance) callRoute(r RouteDef, req *Request, res *Response) (interface{}, error) {
+	if p.js != nil {
+		result, ok, err := p.jsCall(r.HandlerName, routeTimeout, req, res)
+		if !ok {
+			return nil, fmt.Errorf("处理函数 %s 不存在", r.HandlerName)
+		}
+		return result, err
+	}
+	if p.goPool != nil {
+		out, ok, err := p.goCall(r.HandlerName, routeTimeout, func(fn reflect.Type) ([]interface{}, error) {
+			if fn.NumIn() == 1 && fn.In(0).Kind() == reflect.String {
+				return []interface{}{req.Path}, nil
+			} else if fn.NumIn() == 2 {
+				return []interface{}{req, res}, nil
+			}
+			return nil, fmt.Errorf("处理函数 %s 的参数不正确", r.HandlerName)
+		})
+		if !ok {
+			return nil, fmt.Errorf("处理函数 %s 不存在", r.HandlerName)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(out) > 0 {
+			return out[0].Interface(), nil
+		}
+	}
+	return nil, nil
 }
 
 // === 辅助方法 ===
@@ -220,7 +421,7 @@ func GetAllPlugins() []PluginMetadata {
 	defer mu.RUnlock()
 	var list []PluginMetadata
 	for _, p := range Instances {
-		list = append(list, p.Meta)
+		list = append(list, p.status())
 	}
 	return list
 }
@@ -234,102 +435,101 @@ func GetInstance(id string) (*PluginInstance, bool) {
 
 // === JS 引擎 ===
 func loadJS(p *PluginInstance, src string) {
-	vm := goja.New()
-	p.JsVM = vm
-
-	vm.Set("PluginConfig", p.Config)
-	vm.Set("console", map[string]interface{}{
-		"log": func(call goja.FunctionCall) goja.Value {
-			log.Printf("[JS:%s] %s", p.Meta.Name, call.Argument(0).String())
-			return goja.Undefined()
-		},
-	})
-	vm.Set("RegisterRoute", func(call goja.FunctionCall) goja.Value {
-		p.Routes = append(p.Routes, RouteDef{
-			Method: call.Argument(0).String(), Path: call.Argument(1).String(), HandlerName: call.Argument(2).String(),
-		})
-		return goja.Undefined()
-	})
-
-	_, err := vm.RunString(src)
+	// 第一个虚拟机在加载时创建，用于登记路由与钩子，其余的在并发调用时按需创建
+	vm, err := newJSRuntime(p, src, true)
 	if err != nil {
 		log.Printf("JS Error [%s]: %v", p.Meta.Name, err)
+		p.Meta.Error = err.Error()
 		return
 	}
+	p.js = newVMPool(p.Meta.PoolSize, func() (*goja.Runtime, error) {
+		return newJSRuntime(p, src, false)
+	})
+	p.js.created = 1
 
-	for _, h := range []string{"OnContentRender", "OnMarkdown", "OnRequest", "OnResponse"} {
+	for _, h := range hookNames {
 		registerJSHook(vm, p, h)
 	}
+	p.js.put(vm)
 }
 
 func registerJSHook(vm *goja.Runtime, p *PluginInstance, hookName string) {
-	if fn, ok := goja.AssertFunction(vm.Get(hookName)); ok {
-		p.Hooks[hookName] = func(in string) string {
-			res, _ := fn(goja.Undefined(), vm.ToValue(in))
-			return res.String()
+	if _, ok := goja.AssertFunction(vm.Get(hookName)); ok && p.allowHook(hookName) {
+		p.Hooks[hookName] = func(in string) (string, error) {
+			res, _, err := p.jsCall(hookName, hookTimeout, in)
+			// 未返回值 (undefined/null) 视为不处理
+			if err != nil || res == nil {
+				return "", err
+			}
+			return fmt.Sprint(res), nil
 		}
 	}
 }
 
 // === Go 引擎 (Yaegi) ===
 func loadGo(p *PluginInstance, src string) {
-	i := interp.New(interp.Options{})
-	i.Use(stdlib.Symbols) // 允许使用 net, json, time 等标准库！
-	p.GoInt = i
-
-	// 注入 Host API
-	i.Use(interp.Exports{
-		"plugin/plugin": {
-			"RegisterRoute": reflect.ValueOf(func(method, path, handler string) {
-				p.Routes = append(p.Routes, RouteDef{Method: method, Path: path, HandlerName: handler})
-			}),
-			"Log": reflect.ValueOf(func(msg string) {
-				log.Printf("[Go:%s] %s", p.Meta.Name, msg)
-			}),
-			// [新增] 允许 Go 脚本获取配置
-			"GetConfig": reflect.ValueOf(func() map[string]string {
-				return p.Config
-			}),
-		},
-	})
-
-	_, err := i.Eval(src)
+	// 与 JS 插件相同，第一个解释器在加载时创建，用于登记路由与钩子，其余的在并发调用时按需创建
+	i, err := newGoInterp(p, src, true)
 	if err != nil {
 		log.Printf("Go Error [%s]: %v", p.Meta.Name, err)
+		p.Meta.Error = err.Error()
 		return
 	}
+	p.goPool = newVMPool(p.Meta.PoolSize, func() (*interp.Interpreter, error) {
+		return newGoInterp(p, src, false)
+	})
+	p.goPool.created = 1
 
-	for _, h := range []string{"OnContentRender", "OnMarkdown", "OnRequest", "OnResponse"} {
+	for _, h := range hookNames {
 		registerGoHook(i, p, h)
 	}
+	p.goPool.put(i)
 }
-
 func registerGoHook(i *interp.Interpreter, p *PluginInstance, hookName string) {
-	if v, err := i.Eval(hookName); err == nil && v.Kind() == reflect.Func {
-		p.Hooks[hookName] = func(in string) string {
-			res := v.Call([]reflect.Value{reflect.ValueOf(in)})
-			if len(res) > 0 {
-				return res[0].String()
+	if v, err := i.Eval(hookName); err == nil && v.Kind() == reflect.Func && p.allowHook(hookName) {
+		p.Hooks[hookName] = func(in string) (string, error) {
+			res, _, err := p.goCall(hookName, hookTimeout, func(reflect.Type) ([]interface{}, error) {
+				return []interface{}{in}, nil
+			})
+			if err != nil || len(res) == 0 {
+				return "", err
 			}
-			return in
+			return res[0].String(), nil
 		}
 	}
 }
 
+func (p *PluginInstance) registerRoute(method, path, handler string) {
+	if !p.Meta.Permissions.allowsRoute(path) {
+		log.Printf("插件 [%s] 未声明路由 %s，已忽略", p.Meta.Name, path)
+		return
+	}
+	p.Routes = append(p.Routes, RouteDef{Method: method, Path: path, HandlerName: handler})
+}
+
+// allowHook 判断插件是否声明了已定义的钩子
+func (p *PluginInstance) allowHook(hookName string) bool {
+	if p.Meta.Permissions.allowsHook(hookName) {
+		return true
+	}
+	log.Printf("插件 [%s] 定义了 %s 但未在权限中声明，已忽略", p.Meta.Name, hookName)
+	return false
+}
+
 func GetActiveRoutes() []string {
 	mu.RLock()
 	defer mu.RUnlock()
 
 	var paths []string
 	for _, p := range Instances {
-		// 只处理已启用的插件
-		if !p.Meta.Active {
+		// 只处理正在运行的插件
+		if !p.running() {
 			continue
 		}
 
 		for _, r := range p.Routes {
-			// 只收录 GET 请求，且排除带参数的动态路由(如 /post/:id)
-			if strings.ToUpper(r.Method) == "GET" && !strings.Contains(r.Path, ":") {
+			// 只收录 GET 请求，且排除带参数的动态路由(如 /post/:id、/files/*)
+			if strings.ToUpper(r.Method) == "GET" && !isDynamicPath(r.Path) {
 				paths = append(paths, r.Path)
 			}
 		}
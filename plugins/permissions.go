@@ -0,0 +1,332 @@
+package plugins
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/traefik/yaegi/interp"
+	"github.com/traefik/yaegi/stdlib"
+)
+
+// Permissions 插件在 plugin.json 中声明的权限，启用时由管理员确认：
+//
+//	"permissions": {
+//	  "network": ["api.example.com", "*.example.org"],  // 可以请求的域名，"*" 表示任意域名
+//	  "filesystem": ["uploads", "plugins/demo/data"],    // 可以读写的路径 (相对于数据目录)
+//	  "database": true,                                  // 只读查询数据库
+//	  "routes": ["/hello", "/demo/*"],                   // 可以注册的路由，以 * 结尾表示前缀
+//	  "hooks": ["OnMarkdown", "OnRequest"]               // 可以注册的钩子
+//	}
+//
+// 未声明的能力不会注入到插件的运行环境中
+type Permissions struct {
+	Network    []string `json:"network,omitempty"`
+	Filesystem []string `json:"filesystem,omitempty"`
+	Database   bool     `json:"database,omitempty"`
+	Routes     []string `json:"routes,omitempty"`
+	Hooks      []string `json:"hooks,omitempty"`
+}
+
+// grantsFile 管理员已确认的权限 (插件 ID -> 权限)，保存在 plugins 目录之外，上传的插件包无法覆盖
+const grantsFile = "plugin_grants.json"
+
+// 插件不能读写的文件，即使声明的路径包含它们；其他插件的目录同样不能访问
+var protectedFiles = []string{"config.json", grantsFile}
+
+// ProtectedPaths 返回其他不能读写的文件或目录 (如数据库文件、证书)，由主程序设置
+var ProtectedPaths func() []string
+
+// Go 插件可以导入的标准库，os、net、os/exec、unsafe 等不在其中；文件与网络访问通过 Host API 按权限提供
+var goPackageAllowlist = []string{
+	"bytes", "container/list", "crypto/md5", "crypto/sha1", "crypto/sha256", "encoding/base64",
+	"encoding/hex", "encoding/json", "errors", "fmt", "hash/crc32", "html", "html/template", "math",
+	"math/rand", "net/url", "path", "regexp", "sort", "strconv", "strings", "text/template", "time",
+	"unicode", "unicode/utf8",
+}
+
+const (
+	fetchTimeout  = 10 * time.Second
+	fetchMaxBytes = 1 << 20
+)
+
+// DBQuery 执行只读查询，由主程序在连接数据库后设置
+var DBQuery func(query string, args ...interface{}) ([]map[string]interface{}, error)
+
+// Describe 返回权限的说明，用于后台确认
+func (p Permissions) Describe() []string {
+	var lines []string
+	if len(p.Network) > 0 {
+		lines = append(lines, "访问网络: "+strings.Join(p.Network, ", "))
+	}
+	if len(p.Filesystem) > 0 {
+		lines = append(lines, "读写文件: "+strings.Join(p.Filesystem, ", "))
+	}
+	if p.Database {
+		lines = append(lines, "查询数据库 (只读，包括用户等全部数据)")
+	}
+	if len(p.Routes) > 0 {
+		lines = append(lines, "注册路由: "+strings.Join(p.Routes, ", "))
+	}
+	if len(p.Hooks) > 0 {
+		lines = append(lines, "使用钩子: "+strings.Join(p.Hooks, ", "))
+	}
+	return lines
+}
+
+// Within 判断权限是否都在已授予的范围内
+func (p Permissions) Within(granted Permissions) bool {
+	if p.Database && !granted.Database {
+		return false
+	}
+	for _, pair := range [][2][]string{
+		{p.Network, granted.Network}, {p.Filesystem, granted.Filesystem}, {p.Routes, granted.Routes}, {p.Hooks, granted.Hooks},
+	} {
+		for _, v := range pair[0] {
+			if !slices.Contains(pair[1], v) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// allowsHook 判断是否声明了钩子
+func (p Permissions) allowsHook(name string) bool {
+	return slices.Contains(p.Hooks, name)
+}
+
+// allowsRoute 判断是否可以注册路由
+func (p Permissions) allowsRoute(path string) bool {
+	for _, pattern := range p.Routes {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok && strings.HasPrefix(path, prefix) || pattern == path {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsHost 判断是否可以请求该域名
+func (p Permissions) allowsHost(host string) bool {
+	host = strings.ToLower(host)
+	for _, pattern := range p.Network {
+		pattern = strings.ToLower(pattern)
+		if pattern == "*" || pattern == host {
+			return true
+		}
+		if suffix, ok := strings.CutPrefix(pattern, "*."); ok && strings.HasSuffix(host, "."+suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate 检查声明的权限是否有效：文件路径必须是数据目录下的子目录或文件，不能授权整个数据目录
+func (p Permissions) Validate() error {
+	for _, path := range p.Filesystem {
+		clean := filepath.Clean(filepath.FromSlash(path))
+		if clean == "." || filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("filesystem 权限无效: %q，只能声明数据目录下的子目录或文件", path)
+		}
+	}
+	return nil
+}
+
+// resolvePath 校验插件访问的路径，返回清理后的相对路径
+// 路径中的符号链接解析后仍须位于授权的目录中，且不能是受保护的文件或其他插件的目录
+func (p *PluginInstance) resolvePath(path string) (string, error) {
+	clean := filepath.Clean(filepath.FromSlash(path))
+	if filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("路径无效: %s", path)
+	}
+	real, err := realPath(clean)
+	if err != nil {
+		return "", fmt.Errorf("路径无效: %s", path)
+	}
+	allowed := false
+	for _, root := range p.Meta.Permissions.Filesystem {
+		root = filepath.Clean(filepath.FromSlash(root))
+		if root == "." {
+			continue
+		}
+		if realRoot, err := realPath(root); err == nil && within(real, realRoot) {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return "", fmt.Errorf("未授权访问路径: %s", path)
+	}
+
+	protected := slices.Clone(protectedFiles)
+	if ProtectedPaths != nil {
+		protected = append(protected, ProtectedPaths()...)
+	}
+	for _, name := range protected {
+		if realProtected, err := realPath(filepath.Clean(name)); err == nil && within(real, realProtected) {
+			return "", fmt.Errorf("禁止访问: %s", path)
+		}
+	}
+	pluginsDir, err1 := realPath("plugins")
+	ownDir, err2 := realPath(filepath.Join("plugins", p.Meta.DirName))
+	if err1 != nil || err2 != nil || within(real, pluginsDir) && !within(real, ownDir) {
+		return "", fmt.Errorf("禁止访问: %s", path)
+	}
+	return clean, nil
+}
+
+// realPath 返回解析符号链接后的绝对路径；路径不存在时解析最近的已存在的上级目录
+func realPath(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	rest := ""
+	for {
+		if real, err := filepath.EvalSymlinks(abs); err == nil {
+			return filepath.Join(real, rest), nil
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return "", err
+		}
+		parent := filepath.Dir(abs)
+		if parent == abs {
+			return "", os.ErrNotExist
+		}
+		rest = filepath.Join(filepath.Base(abs), rest)
+		abs = parent
+	}
+}
+
+// within 判断 path 是否为 root 本身或位于 root 之下 (均为绝对路径)
+func within(path, root string) bool {
+	return path == root || strings.HasPrefix(path, root+string(filepath.Separator))
+}
+
+// === 授权记录 ===
+
+func loadGrants() map[string]Permissions {
+	grants := make(map[string]Permissions)
+	if data, err := os.ReadFile(grantsFile); err == nil {
+		json.Unmarshal(data, &grants)
+	}
+	return grants
+}
+
+func saveGrants(grants map[string]Permissions) error {
+	data, err := json.MarshalIndent(grants, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(grantsFile, data, 0600)
+}
+
+// Grant 记录管理员授予插件的权限 (启用插件时调用)
+func Grant(id string, perms Permissions) error {
+	grants := loadGrants()
+	grants[id] = perms
+	return saveGrants(grants)
+}
+
+// Revoke 撤销插件的授权 (禁用或删除插件时调用)，再次启用时需要重新确认
+func Revoke(id string) error {
+	grants := loadGrants()
+	if _, ok := grants[id]; !ok {
+		return nil
+	}
+	delete(grants, id)
+	return saveGrants(grants)
+}
+
+// === 按权限提供的 Host API ===
+
+// hostAPI 返回插件可用的受限函数 (名称 -> 函数)，JS 与 Go 插件共用
+func hostAPI(p *PluginInstance) map[string]interface{} {
+	perms := p.Meta.Permissions
+	api := make(map[string]interface{})
+	if len(perms.Network) > 0 {
+		client := &http.Client{
+			Timeout: fetchTimeout,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if !perms.allowsHost(req.URL.Hostname()) {
+					return fmt.Errorf("未授权访问域名: %s", req.URL.Hostname())
+				}
+				return nil
+			},
+		}
+		api["Fetch"] = func(method, rawURL, body string) (map[string]interface{}, error) {
+			u, err := url.Parse(rawURL)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+				return nil, fmt.Errorf("地址无效: %s", rawURL)
+			}
+			if !perms.allowsHost(u.Hostname()) {
+				return nil, fmt.Errorf("未授权访问域名: %s", u.Hostname())
+			}
+			if method == "" {
+				method = http.MethodGet
+			}
+			req, err := http.NewRequest(strings.ToUpper(method), u.String(), strings.NewReader(body))
+			if err != nil {
+				return nil, err
+			}
+			res, err := client.Do(req)
+			if err != nil {
+				return nil, err
+			}
+			defer res.Body.Close()
+			data, err := io.ReadAll(io.LimitReader(res.Body, fetchMaxBytes))
+			if err != nil {
+				return nil, err
+			}
+			return map[string]interface{}{"status": res.StatusCode, "body": string(data)}, nil
+		}
+	}
+	if len(perms.Filesystem) > 0 {
+		api["ReadFile"] = func(path string) (string, error) {
+			clean, err := p.resolvePath(path)
+			if err != nil {
+				return "", err
+			}
+			data, err := os.ReadFile(clean)
+			return string(data), err
+		}
+		api["WriteFile"] = func(path, content string) error {
+			clean, err := p.resolvePath(path)
+			if err != nil {
+				return err
+			}
+			if err := os.MkdirAll(filepath.Dir(clean), 0755); err != nil {
+				return err
+			}
+			return os.WriteFile(clean, []byte(content), 0644)
+		}
+	}
+	if perms.Database {
+		api["Query"] = func(query string, args ...interface{}) ([]map[string]interface{}, error) {
+			if DBQuery == nil {
+				return nil, errors.New("数据库未连接")
+			}
+			return DBQuery(query, args...)
+		}
+	}
+	return api
+}
+
+// goSymbols 返回 Go 插件可以导入的标准库符号
+func goSymbols() interp.Exports {
+	symbols := make(interp.Exports)
+	for key, pkg := range stdlib.Symbols {
+		// 键的格式为 "导入路径/包名"
+		if i := strings.LastIndex(key, "/"); i > 0 && slices.Contains(goPackageAllowlist, key[:i]) {
+			symbols[key] = pkg
+		}
+	}
+	return symbols
+}
@@ -0,0 +1,76 @@
+package plugins
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// goja.Runtime 与 Yaegi 解释器都不能被多个 goroutine 同时使用，每个插件维护一组初始化好的虚拟机，
+// 每次调用钩子或路由时借出一个，用完归还。虚拟机之间不共享全局变量，需要共享的状态应保存在存储中
+const (
+	defaultPoolSize = 4
+	maxPoolSize     = 32
+)
+
+var errPoolBusy = errors.New("没有空闲的虚拟机")
+
+// vmPool 插件的虚拟机池，虚拟机按需创建，最多 size 个
+type vmPool[T any] struct {
+	idle    chan T
+	newVM   func() (T, error)
+	mu      sync.Mutex
+	size    int
+	created int
+}
+
+func newVMPool[T any](size int, newVM func() (T, error)) *vmPool[T] {
+	if size <= 0 {
+		size = defaultPoolSize
+	}
+	size = min(size, maxPoolSize)
+	return &vmPool[T]{idle: make(chan T, size), newVM: newVM, size: size}
+}
+
+// get 借出一个虚拟机：优先使用空闲的，未达到上限时新建，否则等待归还 (最多 timeout)
+func (pool *vmPool[T]) get(timeout time.Duration) (T, error) {
+	select {
+	case vm := <-pool.idle:
+		return vm, nil
+	default:
+	}
+
+	pool.mu.Lock()
+	if pool.created < pool.size {
+		pool.created++
+		pool.mu.Unlock()
+		vm, err := pool.newVM()
+		if err != nil {
+			pool.discard()
+		}
+		return vm, err
+	}
+	pool.mu.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case vm := <-pool.idle:
+		return vm, nil
+	case <-timer.C:
+		var zero T
+		return zero, errPoolBusy
+	}
+}
+
+// put 归还虚拟机
+func (pool *vmPool[T]) put(vm T) {
+	pool.idle <- vm
+}
+
+// discard 丢弃借出后无法继续使用的虚拟机，之后可以新建一个补上
+func (pool *vmPool[T]) discard() {
+	pool.mu.Lock()
+	pool.created--
+	pool.mu.Unlock()
+}
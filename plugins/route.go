@@ -0,0 +1,196 @@
+package plugins
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Request 传给插件路由处理函数的请求
+//
+//	JS:  function handler(req, res) { return "id=" + req.params.id + " q=" + req.query.q; }
+//	Go:  func Handler(req *plugin.Request, res *plugin.Response) interface{} { return req.Params["id"] }
+//
+// JS 中字段名与 json 标签一致，方法名首字母小写 (req.params、res.setHeader)
+type Request struct {
+	Method  string            `json:"method"`
+	Path    string            `json:"path"`
+	Params  map[string]string `json:"params"` // 路径参数，如 /post/:id 中的 id；通配符 * 与 + 的值以 "*"、"+" 为键
+	Query   map[string]string `json:"query"`
+	Headers map[string]string `json:"headers"`
+	Cookies map[string]string `json:"cookies"`
+	Form    map[string]string `json:"form"` // 表单字段 (application/x-www-form-urlencoded 与 multipart/form-data)
+	Body    string            `json:"body"`
+	JSON    interface{}       `json:"json"` // Content-Type 为 JSON 时解析后的请求体
+	IP      string            `json:"ip"`
+}
+
+// String 返回请求路径，兼容把参数当作路径字符串使用的旧插件
+func (r *Request) String() string {
+	return r.Path
+}
+
+// Response 插件路由设置的响应状态、响应头、Cookie 与跳转
+type Response struct {
+	StatusCode int               `json:"statusCode"`
+	Headers    map[string]string `json:"headers"`
+	Cookies    []*fiber.Cookie   `json:"-"`
+	Location   string            `json:"location"` // 跳转地址
+}
+
+// Status 设置状态码
+func (r *Response) Status(code int) {
+	r.StatusCode = code
+}
+
+// SetHeader 设置响应头
+func (r *Response) SetHeader(name, value string) {
+	r.Headers[name] = value
+}
+
+// SetCookie 设置 Cookie，maxAge 为有效秒数，0 表示会话 Cookie，小于 0 表示删除
+func (r *Response) SetCookie(name, value string, maxAge int) {
+	cookie := &fiber.Cookie{Name: name, Value: value, Path: "/", HTTPOnly: true, SameSite: fiber.CookieSameSiteLaxMode}
+	if maxAge > 0 {
+		cookie.MaxAge = maxAge
+		cookie.Expires = time.Now().Add(time.Duration(maxAge) * time.Second)
+	} else if maxAge < 0 {
+		cookie.Value, cookie.Expires = "", time.Unix(0, 0)
+	}
+	r.Cookies = append(r.Cookies, cookie)
+}
+
+// HasHeader 判断是否设置了响应头 (不区分大小写)
+func (r *Response) HasHeader(name string) bool {
+	for k := range r.Headers {
+		if strings.EqualFold(k, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Redirect 跳转到 url，未设置状态码时使用 302
+func (r *Response) Redirect(url string) {
+	r.Location = url
+}
+
+// Apply 将状态码、响应头与 Cookie 写入 Fiber 响应
+func (r *Response) Apply(c *fiber.Ctx) {
+	if r.Location != "" && (r.StatusCode < 300 || r.StatusCode > 399) {
+		r.StatusCode = fiber.StatusFound
+	}
+	if r.StatusCode != 0 {
+		c.Status(r.StatusCode)
+	}
+	for name, value := range r.Headers {
+		c.Set(name, value)
+	}
+	secure := c.Protocol() == "https"
+	for _, cookie := range r.Cookies {
+		cookie.Secure = secure
+		c.Cookie(cookie)
+	}
+	if r.Location != "" {
+		c.Location(r.Location)
+	}
+}
+
+// 不传给插件的请求头：插件可以把请求内容写入存储，带有凭据的请求头会让插件冒用管理员的会话或 API 令牌
+var privateHeaders = []string{fiber.HeaderAuthorization, fiber.HeaderProxyAuthorization, fiber.HeaderCookie, "X-Csrf-Token"}
+
+// PrivateCookies 不传给插件的 Cookie (会话与 CSRF)，由主程序设置
+var PrivateCookies []string
+
+// newRequest 从 Fiber 请求构造插件请求；字符串都复制一份，插件可能在请求结束后仍持有它们
+// 会话 Cookie、CSRF 令牌与鉴权请求头不会传给插件
+func newRequest(c *fiber.Ctx, params map[string]string) *Request {
+	req := &Request{
+		Method:  strings.Clone(c.Method()),
+		Path:    strings.Clone(c.Path()),
+		Params:  params,
+		Query:   make(map[string]string),
+		Headers: make(map[string]string),
+		Cookies: make(map[string]string),
+		Form:    make(map[string]string),
+		Body:    string(c.Body()),
+		IP:      strings.Clone(c.IP()),
+	}
+	c.Request().URI().QueryArgs().VisitAll(func(k, v []byte) {
+		req.Query[string(k)] = string(v)
+	})
+	c.Request().Header.VisitAll(func(k, v []byte) {
+		if !slices.ContainsFunc(privateHeaders, func(h string) bool { return strings.EqualFold(h, string(k)) }) {
+			req.Headers[string(k)] = string(v)
+		}
+	})
+	c.Request().Header.VisitAllCookie(func(k, v []byte) {
+		if !slices.Contains(PrivateCookies, string(k)) {
+			req.Cookies[string(k)] = string(v)
+		}
+	})
+	switch ct := strings.ToLower(string(c.Request().Header.ContentType())); {
+	case strings.HasPrefix(ct, fiber.MIMEApplicationForm):
+		c.Request().PostArgs().VisitAll(func(k, v []byte) {
+			req.Form[string(k)] = string(v)
+		})
+	case strings.HasPrefix(ct, fiber.MIMEMultipartForm):
+		if form, err := c.MultipartForm(); err == nil {
+			for k, v := range form.Value {
+				if len(v) > 0 {
+					req.Form[strings.Clone(k)] = strings.Clone(v[0])
+				}
+			}
+		}
+	case strings.HasPrefix(ct, fiber.MIMEApplicationJSON):
+		json.Unmarshal(c.Body(), &req.JSON)
+	}
+	return req
+}
+
+// matchPath 按 Fiber 的语法匹配路由：:name 匹配一段，末尾的 :name? 可省略，* 匹配剩余部分 (可为空)，+ 匹配剩余部分 (不能为空)
+func matchPath(pattern, path string) (map[string]string, bool) {
+	pp, segs := splitPath(pattern), splitPath(path)
+	params := make(map[string]string)
+	for i, p := range pp {
+		if p == "*" || p == "+" {
+			rest := strings.Join(segs[min(i, len(segs)):], "/")
+			if p == "+" && rest == "" {
+				return nil, false
+			}
+			params[p] = rest
+			return params, true
+		}
+		if i >= len(segs) {
+			if strings.HasPrefix(p, ":") && strings.HasSuffix(p, "?") && i == len(pp)-1 {
+				params[strings.TrimSuffix(p[1:], "?")] = ""
+				return params, true
+			}
+			return nil, false
+		}
+		if name, ok := strings.CutPrefix(p, ":"); ok {
+			params[strings.TrimSuffix(name, "?")] = segs[i]
+		} else if segs[i] != p {
+			return nil, false
+		}
+	}
+	if len(segs) != len(pp) {
+		return nil, false
+	}
+	return params, true
+}
+
+func splitPath(path string) []string {
+	if path = strings.Trim(path, "/"); path == "" {
+		return nil
+	}
+	return strings.Split(path, "/")
+}
+
+// isDynamicPath 判断路由是否带参数或通配符
+func isDynamicPath(path string) bool {
+	return strings.ContainsAny(path, ":*+")
+}
@@ -0,0 +1,45 @@
+package plugins
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// 会话 Cookie、CSRF 令牌与鉴权请求头不能传给插件
+func TestNewRequestHidesCredentials(t *testing.T) {
+	old := PrivateCookies
+	PrivateCookies = []string{"session_id", "csrf_"}
+	defer func() { PrivateCookies = old }()
+
+	var req *Request
+	app := fiber.New()
+	app.Get("/*", func(c *fiber.Ctx) error {
+		req = newRequest(c, nil)
+		return nil
+	})
+	r := httptest.NewRequest("GET", "/page", nil)
+	r.Header.Set("Authorization", "Bearer secret-token")
+	r.Header.Set("Proxy-Authorization", "Basic secret")
+	r.Header.Set("X-Csrf-Token", "csrf-token")
+	r.Header.Set("Cookie", "session_id=secret-session; csrf_=csrf-cookie; theme=dark")
+	r.Header.Set("Accept-Language", "zh-CN")
+	if _, err := app.Test(r); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, h := range []string{"Authorization", "Proxy-Authorization", "X-Csrf-Token", "Cookie"} {
+		if v, ok := req.Headers[h]; ok {
+			t.Errorf("请求头 %s 传给了插件: %q", h, v)
+		}
+	}
+	for _, name := range []string{"session_id", "csrf_"} {
+		if v, ok := req.Cookies[name]; ok {
+			t.Errorf("Cookie %s 传给了插件: %q", name, v)
+		}
+	}
+	if req.Cookies["theme"] != "dark" || req.Headers["Accept-Language"] != "zh-CN" {
+		t.Errorf("其他请求头与 Cookie 应当保留: %v %v", req.Headers, req.Cookies)
+	}
+}
@@ -0,0 +1,85 @@
+package plugins
+
+import (
+	"errors"
+	"time"
+)
+
+// Storage 插件的键值存储，数据按插件 ID 隔离，由主程序在连接数据库后设置
+//
+//	JS:  Storage.set("views", "1", 3600); Storage.get("views"); Storage.delete("views"); Storage.list("cache:")
+//	Go:  plugin.StorageSet("views", "1", 3600); v, ok := plugin.StorageGet("views")
+//
+// ttl 为有效秒数，0 表示永不过期；get 在键不存在或已过期时返回 null (Go 中 ok 为 false)
+type Storage interface {
+	Get(pluginID, key string) (string, bool, error)
+	Set(pluginID, key, value string, ttl time.Duration) error
+	Delete(pluginID, key string) error
+	List(pluginID, prefix string) ([]string, error)
+	Clear(pluginID string) error
+}
+
+// Store 插件存储的实现
+var Store Storage
+
+var errNoStorage = errors.New("存储不可用")
+
+// pluginStorage 绑定到单个插件的存储，注入到插件的运行环境中
+type pluginStorage struct {
+	id string
+}
+
+// Get 返回键对应的值，不存在时返回 nil (JS 中为 null)
+func (s pluginStorage) Get(key string) (interface{}, error) {
+	if Store == nil {
+		return nil, errNoStorage
+	}
+	value, ok, err := Store.Get(s.id, key)
+	if err != nil || !ok {
+		return nil, err
+	}
+	return value, nil
+}
+
+func (s pluginStorage) Set(key, value string, ttlSeconds int) error {
+	if Store == nil {
+		return errNoStorage
+	}
+	return Store.Set(s.id, key, value, time.Duration(ttlSeconds)*time.Second)
+}
+
+func (s pluginStorage) Delete(key string) error {
+	if Store == nil {
+		return errNoStorage
+	}
+	return Store.Delete(s.id, key)
+}
+
+func (s pluginStorage) List(prefix string) ([]string, error) {
+	if Store == nil {
+		return nil, errNoStorage
+	}
+	return Store.List(s.id, prefix)
+}
+
+// goStorageAPI 返回导出给 Go 插件的存储函数
+func goStorageAPI(s pluginStorage) map[string]interface{} {
+	return map[string]interface{}{
+		"StorageGet": func(key string) (string, bool) {
+			v, err := s.Get(key)
+			value, ok := v.(string)
+			return value, ok && err == nil
+		},
+		"StorageSet":    s.Set,
+		"StorageDelete": s.Delete,
+		"StorageList":   s.List,
+	}
+}
+
+// ClearStorage 删除插件的全部存储数据 (删除插件时调用)
+func ClearStorage(id string) error {
+	if Store == nil {
+		return nil
+	}
+	return Store.Clear(id)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"gopress/plugins"
+)
+
+// 插件存储的默认配额与单项限制
+const (
+	defaultPluginStorageKeys  = 1000
+	defaultPluginStorageBytes = 1 << 20
+	maxPluginKeyLength        = 191
+	maxPluginValueBytes       = 64 << 10
+)
+
+// dbPluginStorage 基于 GORM 的 plugins.Storage 实现
+type dbPluginStorage struct{}
+
+// notExpired 只查询未过期的数据
+func notExpired(db *gorm.DB) *gorm.DB {
+	return db.Where("(expires_at IS NULL OR expires_at > ?)", time.Now())
+}
+
+func (dbPluginStorage) Get(pluginID, key string) (string, bool, error) {
+	var row PluginData
+	res := DB.Scopes(notExpired).Select("value").Where("plugin_id = ? AND name = ?", pluginID, key).Limit(1).Find(&row)
+	return row.Value, res.RowsAffected > 0, res.Error
+}
+
+// pluginStorageLocks 同一插件的写入依次执行，配额检查与写入之间不会插入其他写入
+var pluginStorageLocks keyLocks
+
+// valueBytes 返回计算值的字节数的 SQL 表达式；SQLite 的 LENGTH 对文本返回字符数，需要先转换为 BLOB
+func valueBytes() string {
+	switch Cfg().DBType {
+	case "mysql", "postgres":
+		return "OCTET_LENGTH(value)"
+	default:
+		return "LENGTH(CAST(value AS BLOB))"
+	}
+}
+
+// Set 保存数据，ttl 为 0 表示永不过期；超出配额时返回错误
+// 配额检查与写入在同一个事务中执行，并按插件加锁，并发的写入不会一起越过配额
+func (dbPluginStorage) Set(pluginID, key, value string, ttl time.Duration) error {
+	if key == "" || len(key) > maxPluginKeyLength {
+		return fmt.Errorf("键的长度应为 1-%d 字节", maxPluginKeyLength)
+	}
+	if len(value) > maxPluginValueBytes {
+		return fmt.Errorf("值不能超过 %d 字节", maxPluginValueBytes)
+	}
+	cfg := Cfg()
+	maxKeys, maxBytes := cfg.PluginStorageKeys, cfg.PluginStorageBytes
+	if maxKeys <= 0 {
+		maxKeys = defaultPluginStorageKeys
+	}
+	if maxBytes <= 0 {
+		maxBytes = defaultPluginStorageBytes
+	}
+
+	unlock := pluginStorageLocks.lock(pluginID)
+	defer unlock()
+	return DB.Transaction(func(tx *gorm.DB) error {
+		// 先写入再检查覆盖后的用量，超出配额时回滚。先写入使事务一开始就持有写锁，
+		// SQLite 中先读后写的事务在其他写入进行时无法升级为写锁，会直接返回 SQLITE_BUSY
+		row := PluginData{PluginID: pluginID, Name: key, Value: value}
+		if ttl > 0 {
+			expires := time.Now().Add(ttl)
+			row.ExpiresAt = &expires
+		}
+		err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "plugin_id"}, {Name: "name"}},
+			DoUpdates: clause.AssignmentColumns([]string{"value", "expires_at", "updated_at"}),
+		}).Create(&row).Error
+		if err != nil {
+			return err
+		}
+
+		var usage struct {
+			KeyCount  int64
+			ByteCount int64
+		}
+		err = tx.Model(&PluginData{}).Scopes(notExpired).Select("COUNT(*) AS key_count, COALESCE(SUM("+valueBytes()+"), 0) AS byte_count").
+			Where("plugin_id = ?", pluginID).Scan(&usage).Error
+		if err != nil {
+			return err
+		}
+		if usage.KeyCount > int64(maxKeys) {
+			return fmt.Errorf("超出存储配额: 最多 %d 个键", maxKeys)
+		}
+		if usage.ByteCount > int64(maxBytes) {
+			return fmt.Errorf("超出存储配额: 最多 %d 字节", maxBytes)
+		}
+		return nil
+	})
+}
+
+func (dbPluginStorage) Delete(pluginID, key string) error {
+	return DB.Where("plugin_id = ? AND name = ?", pluginID, key).Delete(&PluginData{}).Error
+}
+
+// List 返回以 prefix 开头的键 (按键排序)
+func (dbPluginStorage) List(pluginID, prefix string) ([]string, error) {
+	keys := []string{}
+	query := DB.Model(&PluginData{}).Scopes(notExpired).Where("plugin_id = ?", pluginID)
+	if prefix != "" {
+		escaper := strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+		query = query.Where("name LIKE ? ESCAPE '!'", escaper.Replace(prefix)+"%")
+	}
+	err := query.Order("name").Pluck("name", &keys).Error
+	return keys, err
+}
+
+// Clear 删除插件的全部数据 (删除插件时调用)
+func (dbPluginStorage) Clear(pluginID string) error {
+	return DB.Where("plugin_id = ?", pluginID).Delete(&PluginData{}).Error
+}
+
+// PrunePluginData 清理过期的插件数据
+func PrunePluginData() {
+	if DB == nil {
+		return
+	}
+	DB.Where("expires_at < ?", time.Now()).Delete(&PluginData{})
+}
+
+var _ plugins.Storage = dbPluginStorage{}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func newTestStorage(t *testing.T, maxKeys, maxBytes int) dbPluginStorage {
+	t.Helper()
+	old, oldDB := *Cfg(), DB
+	t.Cleanup(func() {
+		SetConfig(old)
+		DB = oldDB
+	})
+	SetConfig(Config{DBName: filepath.Join(t.TempDir(), "test.db"), PluginStorageKeys: maxKeys, PluginStorageBytes: maxBytes})
+	if err := ConnectDB(); err != nil {
+		t.Fatal(err)
+	}
+	return dbPluginStorage{}
+}
+
+// 并发写入同一插件时，配额检查与写入不会交错
+func TestPluginStorageQuotaConcurrent(t *testing.T) {
+	store := newTestStorage(t, 10, 1<<20)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var unexpected []error
+	for i := 0; i < 40; i++ {
+		wg.Add(2)
+		for _, plugin := range []string{"a", "b"} {
+			go func() {
+				defer wg.Done()
+				err := store.Set(plugin, "key"+strings.Repeat("x", i), "v", 0)
+				if err != nil && !strings.Contains(err.Error(), "超出存储配额") {
+					mu.Lock()
+					unexpected = append(unexpected, err)
+					mu.Unlock()
+				}
+			}()
+		}
+	}
+	wg.Wait()
+	if err := errors.Join(unexpected...); err != nil {
+		t.Fatal(err)
+	}
+	for _, plugin := range []string{"a", "b"} {
+		keys, err := store.List(plugin, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(keys) != 10 {
+			t.Fatalf("插件 %s 保存了 %d 个键，配额为 10", plugin, len(keys))
+		}
+	}
+}
+
+// 配额按字节计算，多字节字符与 Go 中的 len 一致
+func TestPluginStorageQuotaBytes(t *testing.T) {
+	store := newTestStorage(t, 100, 12)
+
+	if err := store.Set("a", "k1", "你好", 0); err != nil { // 6 字节
+		t.Fatal(err)
+	}
+	if err := store.Set("a", "k2", "世界", 0); err != nil { // 共 12 字节
+		t.Fatal(err)
+	}
+	if err := store.Set("a", "k3", "!", 0); err == nil {
+		t.Fatal("超出字节配额时应当失败")
+	}
+	// 覆盖已有的键按覆盖后的用量计算
+	if err := store.Set("a", "k2", "abcdef", 0); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ErrForbidden 当前用户没有执行该操作的权限
+var ErrForbidden = errors.New("权限不足")
+
+// InputError 用户输入不合法 (后台显示提示，API 返回 422)
+type InputError struct {
+	Message string
+}
+
+func (e *InputError) Error() string { return e.Message }
+
+func inputError(msg string) error {
+	return &InputError{Message: msg}
+}
+
+// PostInput 创建 / 更新文章的输入，后台表单与 API 共用
+type PostInput struct {
+	Title       string
+	Slug        string
+	Content     string
+	Type        string // post / page，更新时忽略
+	Status      string
+	PublishedAt string // 2006-01-02T15:04 或 RFC3339，可为空
+}
+
+// PostInputFromForm 读取后台编辑器提交的表单
+func PostInputFromForm(c *fiber.Ctx) PostInput {
+	return PostInput{
+		Title:       c.FormValue("title"),
+		Slug:        c.FormValue("slug"),
+		Content:     c.FormValue("content"),
+		Type:        c.FormValue("type"),
+		Status:      c.FormValue("status"),
+		PublishedAt: c.FormValue("published_at"),
+	}
+}
+
+// SavePost 校验权限与输入后保存文章，post.ID 为 0 时创建
+// 更新时内容有变化会先保存修订，并清除当前用户的自动草稿
+func SavePost(c *fiber.Ctx, post *Post, in PostInput) error {
+	uid := currentUserID(c)
+	if post.ID == 0 {
+		post.Type = "post"
+		if in.Type == "page" {
+			post.Type = "page"
+		}
+		post.AuthorID = uid
+		if post.Type == "page" && !can(c, CapEditPages) {
+			return ErrForbidden
+		}
+		if !can(c, CapEditPosts) {
+			return ErrForbidden
+		}
+	} else if !CanEditPost(c, *post) {
+		return ErrForbidden
+	}
+
+	in.Title = strings.TrimSpace(in.Title)
+	if in.Title == "" {
+		return inputError("标题不能为空")
+	}
+	in.Slug = strings.TrimSpace(in.Slug)
+	if in.Slug == "" {
+		in.Slug = Slugify(in.Title)
+		if in.Slug == "" {
+			in.Slug = strconv.FormatInt(time.Now().Unix(), 10)
+		}
+	}
+	var exists int64
+	DB.Unscoped().Model(&Post{}).Where("slug = ? AND id <> ?", in.Slug, post.ID).Count(&exists)
+	if exists > 0 {
+		return inputError("Slug 已被其他内容使用")
+	}
+
+	if post.ID != 0 {
+		if post.Title != in.Title || post.Slug != in.Slug || post.Content != in.Content {
+			SaveRevision(post)
+		}
+		DB.Unscoped().Where("post_id = ? AND author_id = ? AND autosave = ?", post.ID, uid, true).Delete(&PostRevision{})
+	}
+	post.Title, post.Slug, post.Content = in.Title, in.Slug, in.Content
+	post.EditorID = uid
+	ApplyPostStatus(post, AllowedStatus(c, in.Status), in.PublishedAt)
+	return DB.Save(post).Error
+}
+
+// DeletePost 删除文章及其评论
+func DeletePost(c *fiber.Ctx, post Post) error {
+	if !CanEditPost(c, post) {
+		return ErrForbidden
+	}
+	if err := DB.Delete(&post).Error; err != nil {
+		return err
+	}
+	DB.Where("post_id = ?", post.ID).Delete(&Comment{})
+	return nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"strings"
+)
+
+// 修订差异行类型
+const (
+	DiffSame = "same"
+	DiffAdd  = "add"
+	DiffDel  = "del"
+)
+
+// DiffLine 行级差异中的一行
+type DiffLine struct {
+	Type string
+	Text string
+}
+
+// maxDiffCells LCS 表的最大规模 (行数之积)，超过时不再逐行对齐，避免大文章占用过多内存
+const maxDiffCells = 4 << 20
+
+// SaveRevision 将文章当前的标题/Slug/正文存为一条修订记录，记录该版本的最后编辑者
+func SaveRevision(post *Post) {
+	editor := post.EditorID
+	if editor == 0 {
+		editor = post.AuthorID
+	}
+	DB.Create(&PostRevision{
+		PostID:   post.ID,
+		Title:    post.Title,
+		Slug:     post.Slug,
+		Content:  post.Content,
+		AuthorID: editor,
+	})
+}
+
+// SaveAutosave 保存编辑器自动草稿，每个用户每篇文章只保留最新的一份，不影响公开版本
+func SaveAutosave(postID, authorID uint, title, slug, content string) error {
+	var rev PostRevision
+	DB.Where("post_id = ? AND author_id = ? AND autosave = ?", postID, authorID, true).First(&rev)
+	rev.PostID, rev.AuthorID, rev.Autosave = postID, authorID, true
+	rev.Title, rev.Slug, rev.Content = title, slug, content
+	return DB.Save(&rev).Error
+}
+
+// DiffLines 基于最长公共子序列计算 from -> to 的行级差异
+func DiffLines(from, to string) []DiffLine {
+	a := strings.Split(strings.ReplaceAll(from, "\r\n", "\n"), "\n")
+	b := strings.Split(strings.ReplaceAll(to, "\r\n", "\n"), "\n")
+
+	// 去掉相同的首尾部分，减少 LCS 表的规模
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	midA, midB := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+
+	var lines []DiffLine
+	for _, l := range a[:prefix] {
+		lines = append(lines, DiffLine{Type: DiffSame, Text: l})
+	}
+	// 差异部分过大时整体显示为删除 + 新增
+	if len(midA)*len(midB) > maxDiffCells {
+		for _, l := range midA {
+			lines = append(lines, DiffLine{Type: DiffDel, Text: l})
+		}
+		for _, l := range midB {
+			lines = append(lines, DiffLine{Type: DiffAdd, Text: l})
+		}
+		for _, l := range a[len(a)-suffix:] {
+			lines = append(lines, DiffLine{Type: DiffSame, Text: l})
+		}
+		return lines
+	}
+
+	// lcs[i][j] = midA[i:] 与 midB[j:] 的最长公共子序列长度
+	lcs := make([][]int32, len(midA)+1)
+	for i := range lcs {
+		lcs[i] = make([]int32, len(midB)+1)
+	}
+	for i := len(midA) - 1; i >= 0; i-- {
+		for j := len(midB) - 1; j >= 0; j-- {
+			if midA[i] == midB[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(midA) && j < len(midB) {
+		switch {
+		case midA[i] == midB[j]:
+			lines = append(lines, DiffLine{Type: DiffSame, Text: midA[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			lines = append(lines, DiffLine{Type: DiffDel, Text: midA[i]})
+			i++
+		default:
+			lines = append(lines, DiffLine{Type: DiffAdd, Text: midB[j]})
+			j++
+		}
+	}
+	for ; i < len(midA); i++ {
+		lines = append(lines, DiffLine{Type: DiffDel, Text: midA[i]})
+	}
+	for ; j < len(midB); j++ {
+		lines = append(lines, DiffLine{Type: DiffAdd, Text: midB[j]})
+	}
+	for _, l := range a[len(a)-suffix:] {
+		lines = append(lines, DiffLine{Type: DiffSame, Text: l})
+	}
+	return lines
+}
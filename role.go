@@ -0,0 +1,154 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// 用户角色
+const (
+	RoleAdmin       = "admin"       // 管理员：全部权限
+	RoleEditor      = "editor"      // 编辑：管理所有文章、页面、分类与评论
+	RoleAuthor      = "author"      // 作者：发布和管理自己的文章
+	RoleContributor = "contributor" // 投稿者：撰写自己的文章，需审核后发布
+)
+
+// 权限项
+const (
+	CapManageOptions    = "manage_options"    // 基本设置
+	CapManagePlugins    = "manage_plugins"    // 插件管理
+	CapManageThemes     = "manage_themes"     // 主题与外观
+	CapManageUsers      = "manage_users"      // 用户管理
+	CapEditOthersPosts  = "edit_others_posts" // 编辑他人的文章
+	CapEditPages        = "edit_pages"        // 独立页面
+	CapManageCategories = "manage_categories" // 分类与标签
+	CapModerateComments = "moderate_comments" // 评论审核
+	CapPublishPosts     = "publish_posts"     // 直接发布
+	CapUploadFiles      = "upload_files"      // 上传媒体
+	CapEditPosts        = "edit_posts"        // 撰写自己的文章
+)
+
+// RoleCapabilities 角色与权限的对应关系
+var RoleCapabilities = map[string][]string{
+	RoleAdmin: {
+		CapManageOptions, CapManagePlugins, CapManageThemes, CapManageUsers,
+		CapEditOthersPosts, CapEditPages, CapManageCategories, CapModerateComments,
+		CapPublishPosts, CapUploadFiles, CapEditPosts,
+	},
+	RoleEditor: {
+		CapEditOthersPosts, CapEditPages, CapManageCategories, CapModerateComments,
+		CapPublishPosts, CapUploadFiles, CapEditPosts,
+	},
+	RoleAuthor:      {CapPublishPosts, CapUploadFiles, CapEditPosts},
+	RoleContributor: {CapEditPosts},
+}
+
+// RoleLabels 角色显示名 (按权限从高到低)
+var RoleLabels = []struct{ Value, Label string }{
+	{RoleAdmin, "管理员"},
+	{RoleEditor, "编辑"},
+	{RoleAuthor, "作者"},
+	{RoleContributor, "投稿者"},
+}
+
+// IsValidRole 判断是否为合法的角色
+func IsValidRole(role string) bool {
+	_, ok := RoleCapabilities[role]
+	return ok
+}
+
+// Can 判断用户是否拥有某项权限
+func (u User) Can(capability string) bool {
+	for _, c := range RoleCapabilities[u.Role] {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// RoleLabel 返回角色的显示名
+func (u User) RoleLabel() string {
+	for _, r := range RoleLabels {
+		if r.Value == u.Role {
+			return r.Label
+		}
+	}
+	return u.Role
+}
+
+// DisplayName 返回昵称，未设置时返回用户名
+func (u User) DisplayName() string {
+	if u.Nickname != "" {
+		return u.Nickname
+	}
+	return u.Username
+}
+
+// currentUser 返回鉴权中间件加载的当前用户，未登录时返回空用户 (没有任何权限)
+func currentUser(c *fiber.Ctx) User {
+	if u, ok := c.Locals("user").(User); ok {
+		return u
+	}
+	return User{}
+}
+
+// can 判断当前用户是否拥有某项权限
+func can(c *fiber.Ctx, capability string) bool {
+	return currentUser(c).Can(capability)
+}
+
+// RequireCap 返回校验权限的中间件
+func RequireCap(capability string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if can(c, capability) {
+			return c.Next()
+		}
+		return forbidden(c)
+	}
+}
+
+// forbidden 返回 403，API / AJAX / 令牌请求返回 JSON
+func forbidden(c *fiber.Ctx) error {
+	if strings.HasPrefix(c.Path(), "/api/") || c.Locals("token") != nil || c.XHR() || strings.Contains(c.Get("Accept"), "application/json") {
+		return c.Status(403).JSON(fiber.Map{"error": "权限不足"})
+	}
+	return c.Status(403).Render("views/admin/forbidden", fiber.Map{"Title": "权限不足"}, "views/admin/layout")
+}
+
+// CanEditPost 判断当前用户能否编辑 (及删除) 某篇文章或页面
+// 页面需要 edit_pages；他人的文章需要 edit_others_posts；投稿者不能修改已发布的文章
+func CanEditPost(c *fiber.Ctx, post Post) bool {
+	user := currentUser(c)
+	if post.Type == "page" {
+		return user.Can(CapEditPages)
+	}
+	if post.AuthorID != user.ID {
+		return user.Can(CapEditOthersPosts)
+	}
+	if post.Status == StatusPublished && !user.Can(CapPublishPosts) {
+		return false
+	}
+	return user.Can(CapEditPosts)
+}
+
+// AuthorScope 没有 edit_others_posts 权限时只列出自己的文章
+func AuthorScope(c *fiber.Ctx) func(*gorm.DB) *gorm.DB {
+	user := currentUser(c)
+	return func(db *gorm.DB) *gorm.DB {
+		if user.Can(CapEditOthersPosts) {
+			return db
+		}
+		return db.Where("author_id = ?", user.ID)
+	}
+}
+
+// AllowedStatus 没有发布权限的用户提交的文章只能是草稿或待审核
+func AllowedStatus(c *fiber.Ctx, status string) string {
+	if can(c, CapPublishPosts) || status == StatusDraft {
+		return status
+	}
+	return StatusPending
+}
@@ -0,0 +1,527 @@
+package main
+
+import (
+	"html/template"
+	"log"
+	"strings"
+	"sync"
+	"unicode"
+
+	"gorm.io/gorm"
+)
+
+// 单次检索最多返回的候选结果数
+const searchLimit = 1000
+
+// SearchEngine 全文检索引擎
+// SQLite 使用 FTS5，MySQL 使用 FULLTEXT (ngram)，PostgreSQL 使用 tsvector，均不可用时退回内存索引
+type SearchEngine interface {
+	Name() string
+	// Search 返回按相关度排序的文章 ID
+	Search(terms []string) ([]uint, error)
+	// Index / Remove 在文章保存、删除后调用；数据库引擎由触发器或索引自动维护，可为空实现
+	Index(p *Post)
+	Remove(id uint)
+}
+
+// SearchResult 一条检索结果
+type SearchResult struct {
+	Post    Post
+	Title   template.HTML // 高亮后的标题
+	Snippet template.HTML // 高亮后的摘要片段
+}
+
+var Search SearchEngine = newMemorySearch()
+
+// InitSearch 根据数据库类型初始化检索引擎
+func InitSearch() {
+	var engine SearchEngine
+	var err error
+	if cfg := Cfg(); cfg.SearchEngine != "memory" {
+		switch cfg.DBType {
+		case "mysql":
+			engine, err = newMySQLSearch()
+		case "postgres":
+			engine, err = newPostgresSearch()
+		default:
+			engine, err = newSQLiteSearch()
+		}
+	}
+	if engine == nil || err != nil {
+		if err != nil {
+			log.Println("全文索引初始化失败，使用内存索引:", err)
+		}
+		mem := newMemorySearch()
+		mem.Build()
+		engine = mem
+	}
+	Search = engine
+	log.Println("检索引擎:", Search.Name())
+}
+
+// AfterSave GORM 钩子：同步内存索引
+func (p *Post) AfterSave(tx *gorm.DB) error {
+	if p.ID != 0 && p.Title+p.Content != "" {
+		Search.Index(p)
+	}
+	return nil
+}
+
+// AfterDelete GORM 钩子：同步内存索引
+func (p *Post) AfterDelete(tx *gorm.DB) error {
+	if p.ID != 0 {
+		Search.Remove(p.ID)
+	}
+	return nil
+}
+
+// SearchTerms 将用户输入拆分为检索词
+func SearchTerms(q string) []string {
+	var terms []string
+	seen := make(map[string]bool)
+	for _, t := range strings.Fields(strings.ToLower(q)) {
+		if !seen[t] && len(terms) < 10 {
+			seen[t] = true
+			terms = append(terms, t)
+		}
+	}
+	return terms
+}
+
+// SearchPosts 检索文章并按 scope (可见性等) 过滤，返回当前页的结果与分页信息
+func SearchPosts(q string, scope func(*gorm.DB) *gorm.DB, postType string, page, perPage int, urlFor func(int) string) ([]SearchResult, Pagination) {
+	terms := SearchTerms(q)
+	if len(terms) == 0 {
+		return nil, NewPagination(1, perPage, 0, urlFor)
+	}
+
+	ids, err := Search.Search(terms)
+	if err != nil {
+		log.Printf("检索失败 (%s)，改用 LIKE: %v", Search.Name(), err)
+		ids = likeSearch(terms)
+	}
+	if len(ids) == 0 {
+		return nil, NewPagination(1, perPage, 0, urlFor)
+	}
+
+	// 过滤掉不可见的内容，保持相关度顺序
+	query := DB.Model(&Post{}).Where("id IN ?", ids)
+	if scope != nil {
+		query = query.Scopes(scope)
+	}
+	if postType != "" {
+		query = query.Where("type = ?", postType)
+	}
+	var visibleIDs []uint
+	query.Pluck("id", &visibleIDs)
+	visible := make(map[uint]bool, len(visibleIDs))
+	for _, id := range visibleIDs {
+		visible[id] = true
+	}
+	var ordered []uint
+	for _, id := range ids {
+		if visible[id] {
+			ordered = append(ordered, id)
+		}
+	}
+
+	pagination := NewPagination(page, perPage, int64(len(ordered)), urlFor)
+	start := pagination.Offset()
+	end := start + pagination.PerPage
+	if end > len(ordered) {
+		end = len(ordered)
+	}
+	if start >= end {
+		return nil, pagination
+	}
+	pageIDs := ordered[start:end]
+
+	var posts []Post
+	DB.Preload("Categories").Preload("Tags").Where("id IN ?", pageIDs).Find(&posts)
+	byID := make(map[uint]Post, len(posts))
+	for _, p := range posts {
+		byID[p.ID] = p
+	}
+	results := make([]SearchResult, 0, len(pageIDs))
+	for _, id := range pageIDs {
+		if p, ok := byID[id]; ok {
+			results = append(results, SearchResult{
+				Post:    p,
+				Title:   Highlight(p.Title, terms),
+				Snippet: Highlight(snippetWindow(p.Content, terms), terms),
+			})
+		}
+	}
+	return results, pagination
+}
+
+// likeSearch 通用的 LIKE 检索 (所有数据库都支持，作为兜底)
+func likeSearch(terms []string) []uint {
+	query := DB.Model(&Post{})
+	escaper := strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+	for _, t := range terms {
+		pattern := "%" + escaper.Replace(t) + "%"
+		query = query.Where("(LOWER(title) LIKE ? ESCAPE '!' OR LOWER(content) LIKE ? ESCAPE '!')", pattern, pattern)
+	}
+	var ids []uint
+	query.Order("updated_at desc").Limit(searchLimit).Pluck("id", &ids)
+	return ids
+}
+
+// === SQLite FTS5 ===
+
+type sqliteSearch struct{}
+
+func newSQLiteSearch() (SearchEngine, error) {
+	// trigram 分词器支持中文等无空格语言的子串检索
+	stmts := []string{
+		`CREATE VIRTUAL TABLE IF NOT EXISTS posts_fts USING fts5(title, content, tokenize='trigram')`,
+		`CREATE TRIGGER IF NOT EXISTS posts_fts_ai AFTER INSERT ON posts BEGIN
+			INSERT INTO posts_fts(rowid, title, content) VALUES (new.id, new.title, new.content);
+		END`,
+		`CREATE TRIGGER IF NOT EXISTS posts_fts_ad AFTER DELETE ON posts BEGIN
+			DELETE FROM posts_fts WHERE rowid = old.id;
+		END`,
+		`CREATE TRIGGER IF NOT EXISTS posts_fts_au AFTER UPDATE OF title, content ON posts BEGIN
+			DELETE FROM posts_fts WHERE rowid = old.id;
+			INSERT INTO posts_fts(rowid, title, content) VALUES (new.id, new.title, new.content);
+		END`,
+		// 启动时重建，保证与旧数据一致
+		`DELETE FROM posts_fts`,
+		`INSERT INTO posts_fts(rowid, title, content) SELECT id, title, content FROM posts`,
+	}
+	for _, stmt := range stmts {
+		if err := DB.Exec(stmt).Error; err != nil {
+			return nil, err
+		}
+	}
+	return &sqliteSearch{}, nil
+}
+
+func (s *sqliteSearch) Name() string   { return "sqlite-fts5" }
+func (s *sqliteSearch) Index(p *Post)  {}
+func (s *sqliteSearch) Remove(id uint) {}
+
+func (s *sqliteSearch) Search(terms []string) ([]uint, error) {
+	var parts []string
+	for _, t := range terms {
+		// trigram 至少需要 3 个字符
+		if len([]rune(t)) < 3 {
+			return likeSearch(terms), nil
+		}
+		parts = append(parts, `"`+strings.ReplaceAll(t, `"`, `""`)+`"`)
+	}
+	var ids []uint
+	err := DB.Raw(`SELECT rowid FROM posts_fts WHERE posts_fts MATCH ? ORDER BY bm25(posts_fts, 10.0, 1.0) LIMIT ?`,
+		strings.Join(parts, " "), searchLimit).Scan(&ids).Error
+	return ids, err
+}
+
+// === MySQL FULLTEXT ===
+
+type mysqlSearch struct{}
+
+func newMySQLSearch() (SearchEngine, error) {
+	var count int64
+	err := DB.Raw(`SELECT COUNT(*) FROM information_schema.statistics
+		WHERE table_schema = DATABASE() AND table_name = 'posts' AND index_name = 'idx_posts_fulltext'`).Scan(&count).Error
+	if err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		// ngram 解析器用于中文分词
+		if err := DB.Exec(`ALTER TABLE posts ADD FULLTEXT INDEX idx_posts_fulltext (title, content) WITH PARSER ngram`).Error; err != nil {
+			return nil, err
+		}
+	}
+	return &mysqlSearch{}, nil
+}
+
+func (s *mysqlSearch) Name() string   { return "mysql-fulltext" }
+func (s *mysqlSearch) Index(p *Post)  {}
+func (s *mysqlSearch) Remove(id uint) {}
+
+func (s *mysqlSearch) Search(terms []string) ([]uint, error) {
+	var parts []string
+	for _, t := range terms {
+		parts = append(parts, `+"`+strings.ReplaceAll(t, `"`, ``)+`"`)
+	}
+	q := strings.Join(parts, " ")
+	var ids []uint
+	err := DB.Raw(`SELECT id FROM posts WHERE MATCH(title, content) AGAINST (? IN BOOLEAN MODE)
+		ORDER BY MATCH(title, content) AGAINST (? IN BOOLEAN MODE) DESC LIMIT ?`, q, q, searchLimit).Scan(&ids).Error
+	return ids, err
+}
+
+// === PostgreSQL tsvector ===
+
+type postgresSearch struct{}
+
+const pgDocument = `to_tsvector('simple', coalesce(title, '') || ' ' || coalesce(content, ''))`
+
+func newPostgresSearch() (SearchEngine, error) {
+	if err := DB.Exec(`CREATE INDEX IF NOT EXISTS idx_posts_fts ON posts USING GIN (` + pgDocument + `)`).Error; err != nil {
+		return nil, err
+	}
+	return &postgresSearch{}, nil
+}
+
+func (s *postgresSearch) Name() string   { return "postgres-tsvector" }
+func (s *postgresSearch) Index(p *Post)  {}
+func (s *postgresSearch) Remove(id uint) {}
+
+func (s *postgresSearch) Search(terms []string) ([]uint, error) {
+	// simple 配置不会切分中文，含中日韩文字时使用 LIKE
+	for _, t := range terms {
+		if hasCJK(t) {
+			return likeSearch(terms), nil
+		}
+	}
+	q := strings.Join(terms, " ")
+	var ids []uint
+	err := DB.Raw(`SELECT id FROM posts WHERE `+pgDocument+` @@ plainto_tsquery('simple', ?)
+		ORDER BY ts_rank(`+pgDocument+`, plainto_tsquery('simple', ?)) DESC LIMIT ?`, q, q, searchLimit).Scan(&ids).Error
+	return ids, err
+}
+
+// === 内存倒排索引 (纯 Go 兜底实现) ===
+
+type memorySearch struct {
+	mu    sync.RWMutex
+	index map[string]map[uint]int // 词 -> 文章 ID -> 权重
+	docs  map[uint][]string       // 文章 ID -> 已索引的词 (用于删除)
+}
+
+func newMemorySearch() *memorySearch {
+	return &memorySearch{index: make(map[string]map[uint]int), docs: make(map[uint][]string)}
+}
+
+// Build 从数据库加载全部文章建立索引
+func (s *memorySearch) Build() {
+	if DB == nil {
+		return
+	}
+	var posts []Post
+	DB.Select("id", "title", "content").Find(&posts)
+	for i := range posts {
+		s.Index(&posts[i])
+	}
+}
+
+func (s *memorySearch) Name() string { return "memory" }
+
+func (s *memorySearch) Index(p *Post) {
+	weights := make(map[string]int)
+	for _, tok := range tokenize(p.Title) {
+		weights[tok] += 5 // 标题权重更高
+	}
+	for _, tok := range tokenize(p.Content) {
+		weights[tok]++
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.removeLocked(p.ID)
+	tokens := make([]string, 0, len(weights))
+	for tok, w := range weights {
+		if s.index[tok] == nil {
+			s.index[tok] = make(map[uint]int)
+		}
+		s.index[tok][p.ID] = w
+		tokens = append(tokens, tok)
+	}
+	s.docs[p.ID] = tokens
+}
+
+func (s *memorySearch) Remove(id uint) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.removeLocked(id)
+}
+
+func (s *memorySearch) removeLocked(id uint) {
+	for _, tok := range s.docs[id] {
+		delete(s.index[tok], id)
+		if len(s.index[tok]) == 0 {
+			delete(s.index, tok)
+		}
+	}
+	delete(s.docs, id)
+}
+
+func (s *memorySearch) Search(terms []string) ([]uint, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// 所有词都必须命中
+	var scores map[uint]int
+	for _, term := range terms {
+		for _, tok := range tokenize(term) {
+			postings := s.index[tok]
+			next := make(map[uint]int)
+			for id, w := range postings {
+				if scores == nil {
+					next[id] = w
+				} else if score, ok := scores[id]; ok {
+					next[id] = score + w
+				}
+			}
+			scores = next
+			if len(scores) == 0 {
+				return nil, nil
+			}
+		}
+	}
+
+	ids := make([]uint, 0, len(scores))
+	for id := range scores {
+		ids = append(ids, id)
+	}
+	sortByScore(ids, scores)
+	if len(ids) > searchLimit {
+		ids = ids[:searchLimit]
+	}
+	return ids, nil
+}
+
+func sortByScore(ids []uint, scores map[uint]int) {
+	// 插入排序足够应付单个博客的结果规模，且分数相同时按 ID 倒序 (新文章在前)
+	for i := 1; i < len(ids); i++ {
+		for j := i; j > 0; j-- {
+			a, b := ids[j-1], ids[j]
+			if scores[a] > scores[b] || (scores[a] == scores[b] && a > b) {
+				break
+			}
+			ids[j-1], ids[j] = b, a
+		}
+	}
+}
+
+func isCJK(r rune) bool {
+	return unicode.Is(unicode.Han, r) || unicode.Is(unicode.Hiragana, r) || unicode.Is(unicode.Katakana, r) || unicode.Is(unicode.Hangul, r)
+}
+
+func hasCJK(s string) bool {
+	for _, r := range s {
+		if isCJK(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// tokenize 分词：拉丁文按单词切分，中日韩文字切分为单字与二元组
+func tokenize(text string) []string {
+	var tokens []string
+	var word []rune
+	var cjk []rune
+	flushWord := func() {
+		if len(word) > 0 {
+			tokens = append(tokens, string(word))
+			word = word[:0]
+		}
+	}
+	flushCJK := func() {
+		for i := range cjk {
+			tokens = append(tokens, string(cjk[i]))
+			if i+1 < len(cjk) {
+				tokens = append(tokens, string(cjk[i:i+2]))
+			}
+		}
+		cjk = cjk[:0]
+	}
+	for _, r := range strings.ToLower(text) {
+		switch {
+		case isCJK(r):
+			flushWord()
+			cjk = append(cjk, r)
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			flushCJK()
+			word = append(word, r)
+		default:
+			flushWord()
+			flushCJK()
+		}
+	}
+	flushWord()
+	flushCJK()
+	return tokens
+}
+
+// === 摘要与高亮 ===
+
+// snippetWindow 截取正文中第一个命中词附近的片段
+func snippetWindow(content string, terms []string) string {
+	// 去掉常见的 Markdown 符号，合并空白
+	content = strings.NewReplacer("#", "", "*", "", "`", "", ">", "", "[", "", "]", "", "!", "").Replace(content)
+	runes := []rune(strings.Join(strings.Fields(content), " "))
+	lower := []rune(strings.ToLower(string(runes)))
+	if len(lower) != len(runes) {
+		lower = runes
+	}
+
+	first := -1
+	for _, t := range terms {
+		if idx := indexRunes(lower, []rune(t)); idx >= 0 && (first < 0 || idx < first) {
+			first = idx
+		}
+	}
+	start := 0
+	if first > 40 {
+		start = first - 40
+	}
+	end := start + 160
+	if end > len(runes) {
+		end = len(runes)
+	}
+	snippet := string(runes[start:end])
+	if start > 0 {
+		snippet = "…" + snippet
+	}
+	if end < len(runes) {
+		snippet += "…"
+	}
+	return snippet
+}
+
+// Highlight 转义文本并用 <mark> 包裹命中的检索词
+func Highlight(text string, terms []string) template.HTML {
+	runes := []rune(text)
+	lower := []rune(strings.ToLower(text))
+	if len(lower) != len(runes) {
+		lower = runes
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(runes); {
+		matched := 0
+		for _, t := range terms {
+			tr := []rune(t)
+			if len(tr) > matched && i+len(tr) <= len(lower) && string(lower[i:i+len(tr)]) == t {
+				matched = len(tr)
+			}
+		}
+		if matched > 0 {
+			b.WriteString("<mark>")
+			b.WriteString(template.HTMLEscapeString(string(runes[i : i+matched])))
+			b.WriteString("</mark>")
+			i += matched
+		} else {
+			b.WriteString(template.HTMLEscapeString(string(runes[i])))
+			i++
+		}
+	}
+	return template.HTML(b.String())
+}
+
+func indexRunes(s, sub []rune) int {
+	if len(sub) == 0 {
+		return -1
+	}
+	for i := 0; i+len(sub) <= len(s); i++ {
+		if string(s[i:i+len(sub)]) == string(sub) {
+			return i
+		}
+	}
+	return -1
+}
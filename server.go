@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// 监听与 HTTPS 配置：
+//   - listen: 监听地址，如 :3000、127.0.0.1:8080，或 unix:/run/gopress.sock
+//   - tls_cert / tls_key: 证书与私钥路径，配置后直接提供 HTTPS
+//   - acme_domains: 自动申请证书的域名 (HTTP-01 验证，需要 http_listen 能从公网的 80 端口访问)
+//   - http_listen: 提供 HTTPS 时额外监听的 HTTP 地址，响应 ACME 验证并跳转到 HTTPS
+//   - trusted_proxies: 反向代理的地址，如 ["127.0.0.1", "10.0.0.0/8"]，来自其他地址的 X-Forwarded-Proto 会被忽略
+const (
+	defaultListen   = ":3000"
+	defaultACMEDir  = "certs"
+	unixPrefix      = "unix:"
+	httpReadTimeout = 10 * time.Second
+)
+
+// ListenAddr 返回监听地址
+func ListenAddr() string {
+	if listen := Cfg().Listen; listen != "" {
+		return listen
+	}
+	return defaultListen
+}
+
+// TLSEnabled 判断是否直接提供 HTTPS
+func TLSEnabled() bool {
+	cfg := Cfg()
+	return len(cfg.ACMEDomains) > 0 || (cfg.TLSCert != "" && cfg.TLSKey != "")
+}
+
+// listener 应用的监听器，以及提供 HTTPS 时附带的 HTTP 服务 (ACME 验证与跳转)
+type listener struct {
+	net.Listener
+	http *http.Server
+}
+
+// newListener 按配置创建监听器
+func newListener() (*listener, error) {
+	addr := ListenAddr()
+	var ln net.Listener
+	var err error
+	if path, ok := strings.CutPrefix(addr, unixPrefix); ok {
+		// 上次未正常退出时残留的 socket 文件
+		if info, statErr := os.Stat(path); statErr == nil && info.Mode()&os.ModeSocket != 0 {
+			os.Remove(path)
+		}
+		if ln, err = net.Listen("unix", path); err == nil {
+			err = os.Chmod(path, 0o660)
+		}
+	} else {
+		ln, err = net.Listen("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	l := &listener{Listener: ln}
+	if !TLSEnabled() {
+		return l, nil
+	}
+
+	tlsConfig, challenge, err := newTLSConfig()
+	if err != nil {
+		ln.Close()
+		return nil, err
+	}
+	l.Listener = tls.NewListener(ln, tlsConfig)
+	if httpListen := Cfg().HTTPListen; httpListen != "" {
+		var handler http.Handler = http.HandlerFunc(redirectToHTTPS)
+		if challenge != nil {
+			acmeHandler := challenge.HTTPHandler(handler)
+			// 部分 ACME 服务 (如 Pebble) 验证请求的 Host 带端口，去掉后再交给域名白名单校验
+			handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if host, _, err := net.SplitHostPort(r.Host); err == nil {
+					r.Host = host
+				}
+				acmeHandler.ServeHTTP(w, r)
+			})
+		}
+		l.http = &http.Server{Addr: httpListen, Handler: handler, ReadHeaderTimeout: httpReadTimeout}
+		go func() {
+			if err := l.http.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Println("HTTP 监听失败:", err)
+			}
+		}()
+	} else if challenge != nil {
+		log.Println("警告: 未配置 http_listen，只能通过 TLS-ALPN-01 验证申请证书")
+	}
+	return l, nil
+}
+
+// Shutdown 关闭附带的 HTTP 服务
+func (l *listener) Shutdown(ctx context.Context) {
+	if l.http != nil {
+		l.http.Shutdown(ctx)
+	}
+}
+
+// String 返回用于日志的监听地址
+func (l *listener) String() string {
+	scheme := "http"
+	if TLSEnabled() {
+		scheme = "https"
+	}
+	return scheme + "://" + ListenAddr()
+}
+
+// newTLSConfig 创建 TLS 配置；使用 ACME 时同时返回证书管理器 (用于响应 HTTP-01 验证)
+func newTLSConfig() (*tls.Config, *autocert.Manager, error) {
+	cfg := Cfg()
+	if len(cfg.ACMEDomains) == 0 {
+		cert, err := tls.LoadX509KeyPair(cfg.TLSCert, cfg.TLSKey)
+		if err != nil {
+			return nil, nil, fmt.Errorf("证书加载失败: %w", err)
+		}
+		return &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}, nil, nil
+	}
+
+	cacheDir := cfg.ACMECacheDir
+	if cacheDir == "" {
+		cacheDir = defaultACMEDir
+	}
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(cfg.ACMEDomains...),
+		Cache:      autocert.DirCache(cacheDir),
+		Email:      cfg.ACMEEmail,
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// 测试环境 (如 Pebble) 的 ACME 服务使用自签名证书
+	if cfg.ACMECACert != "" {
+		pem, err := os.ReadFile(cfg.ACMECACert)
+		if err != nil {
+			return nil, nil, fmt.Errorf("ACME CA 证书读取失败: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, nil, errors.New("ACME CA 证书格式错误")
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	m.Client = &acme.Client{
+		DirectoryURL: cfg.ACMEDirectory,
+		HTTPClient:   &http.Client{Timeout: 30 * time.Second, Transport: &acmeOrderTransport{base: transport, orders: map[string]string{}}},
+	}
+	tlsConfig := m.TLSConfig()
+	tlsConfig.MinVersion = tls.VersionTLS12
+	// fasthttp 不支持 HTTP/2，只保留 HTTP/1.1 与 TLS-ALPN-01 验证
+	tlsConfig.NextProtos = []string{"http/1.1", acme.ALPNProto}
+	getCertificate := tlsConfig.GetCertificate
+	tlsConfig.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		cert, err := getCertificate(hello)
+		if err != nil && hello.ServerName != "" {
+			log.Printf("证书获取失败 (%s): %v", hello.ServerName, err)
+		}
+		return cert, err
+	}
+	return tlsConfig, m, nil
+}
+
+// acmeOrderTransport 补全 finalize 响应中的订单地址：
+// 异步签发证书的 CA (如 Pebble) 在 finalize 后返回 processing 状态且不带 Location，
+// autocert 依赖该地址轮询订单，缺少时会失败
+type acmeOrderTransport struct {
+	base   http.RoundTripper
+	mu     sync.Mutex
+	orders map[string]string // finalize 地址 -> 订单地址
+}
+
+func (t *acmeOrderTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := t.base.RoundTrip(req)
+	if err != nil || req.Method != http.MethodPost {
+		return res, err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if loc := res.Header.Get("Location"); loc != "" {
+		// 新建订单的响应：记下订单的 finalize 地址
+		body, err := io.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		res.Body = io.NopCloser(bytes.NewReader(body))
+		var order struct {
+			Finalize string `json:"finalize"`
+		}
+		if json.Unmarshal(body, &order) == nil && order.Finalize != "" {
+			t.orders[order.Finalize] = loc
+		}
+	} else if order, ok := t.orders[req.URL.String()]; ok {
+		res.Header.Set("Location", order)
+		delete(t.orders, req.URL.String())
+	}
+	return res, nil
+}
+
+// redirectToHTTPS 将 HTTP 请求跳转到 HTTPS 的同一地址
+func redirectToHTTPS(w http.ResponseWriter, r *http.Request) {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	// HTTPS 不在 443 端口时带上端口号
+	if _, port, err := net.SplitHostPort(ListenAddr()); err == nil && port != "443" && port != "" {
+		host = net.JoinHostPort(host, port)
+	}
+	http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+}
+
+// withTrustedProxies 只信任来自反向代理的 X-Forwarded-Proto 与 X-Forwarded-For，否则客户端可以伪造协议与地址
+// 来自代理的请求，c.IP() 返回 X-Forwarded-For 中的客户端地址 (由 ClientIPMiddleware 整理)，
+// 否则所有客户端共用代理的地址，登录限制与评论频率限制会把它们当成同一个客户端
+func withTrustedProxies(config fiber.Config, proxies []string) fiber.Config {
+	config.EnableTrustedProxyCheck = true
+	config.TrustedProxies = proxies
+	config.ProxyHeader = fiber.HeaderXForwardedFor
+	config.EnableIPValidation = true
+	return config
+}
+
+// ClientIPMiddleware 把来自受信任代理的 X-Forwarded-For 改写为客户端的真实地址：
+// 代理把连接的地址追加在请求自带的值之后，最左边的值可以由客户端伪造，
+// 因此从右往左跳过受信任的代理，取第一个不受信任的地址
+func ClientIPMiddleware(c *fiber.Ctx) error {
+	if c.Get(fiber.HeaderXForwardedFor) == "" || !c.IsProxyTrusted() {
+		return c.Next()
+	}
+	ips := c.IPs()
+	if len(ips) == 0 {
+		c.Request().Header.Del(fiber.HeaderXForwardedFor)
+		return c.Next()
+	}
+	client := ips[0]
+	for i := len(ips) - 1; i >= 0; i-- {
+		if !isTrustedProxy(ips[i], c.App().Config().TrustedProxies) {
+			client = ips[i]
+			break
+		}
+	}
+	c.Request().Header.Set(fiber.HeaderXForwardedFor, client)
+	return c.Next()
+}
+
+// isTrustedProxy 判断地址是否属于配置的代理 (IP 或网段)
+func isTrustedProxy(ip string, proxies []string) bool {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	for _, p := range proxies {
+		if _, network, err := net.ParseCIDR(p); err == nil {
+			if network.Contains(addr) {
+				return true
+			}
+		} else if proxy := net.ParseIP(p); proxy != nil && proxy.Equal(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// HTTPSMiddleware 按配置跳转到 HTTPS 并发送 HSTS 头
+// 部署在反向代理之后时根据 X-Forwarded-Proto 判断协议，代理的地址需要配置在 trusted_proxies 中
+func HTTPSMiddleware(c *fiber.Ctx) error {
+	cfg := Cfg()
+	if c.Protocol() == "https" {
+		if cfg.HSTSMaxAge > 0 {
+			value := "max-age=" + strconv.Itoa(cfg.HSTSMaxAge)
+			if cfg.HSTSIncludeSubdomains {
+				value += "; includeSubDomains"
+			}
+			c.Set(fiber.HeaderStrictTransportSecurity, value)
+		}
+		return c.Next()
+	}
+	// 健康检查通常直接访问 HTTP 地址，不跳转
+	if cfg.HTTPSRedirect && c.Path() != "/healthz" && c.Path() != "/readyz" {
+		return c.Redirect("https://"+c.Hostname()+c.OriginalURL(), fiber.StatusMovedPermanently)
+	}
+	return c.Next()
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/crypto/acme"
+)
+
+// fakeACME 模拟 Pebble 的 ACME 服务：finalize 后订单处于 processing 状态且响应不带 Location，
+// 之后查询订单时才返回 valid 与证书地址
+type fakeACME struct {
+	*httptest.Server
+	cert []byte // PEM 编码的证书
+
+	mu        sync.Mutex
+	finalized bool
+	polled    int
+}
+
+func newFakeACME(t *testing.T) *fakeACME {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "example.test"},
+		DNSNames:     []string{"example.test"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeACME{cert: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeACME) serve(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Replay-Nonce", fmt.Sprintf("nonce-%d", time.Now().UnixNano()))
+	reply := func(status int, v interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(v)
+	}
+	order := func(status string) map[string]interface{} {
+		o := map[string]interface{}{
+			"status":         status,
+			"identifiers":    []map[string]string{{"type": "dns", "value": "example.test"}},
+			"authorizations": []string{f.URL + "/authz/1"},
+			"finalize":       f.URL + "/finalize/1",
+		}
+		if status == acme.StatusValid {
+			o["certificate"] = f.URL + "/cert/1"
+		}
+		return o
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch r.URL.Path {
+	case "/dir":
+		reply(http.StatusOK, map[string]string{
+			"newNonce": f.URL + "/nonce", "newAccount": f.URL + "/account", "newOrder": f.URL + "/order",
+		})
+	case "/nonce":
+		w.WriteHeader(http.StatusOK)
+	case "/account":
+		w.Header().Set("Location", f.URL+"/account/1")
+		reply(http.StatusCreated, map[string]string{"status": acme.StatusValid})
+	case "/order":
+		w.Header().Set("Location", f.URL+"/order/1")
+		reply(http.StatusCreated, order(acme.StatusReady))
+	case "/finalize/1":
+		f.finalized = true
+		reply(http.StatusOK, order(acme.StatusProcessing))
+	case "/order/1":
+		f.polled++
+		if f.finalized {
+			reply(http.StatusOK, order(acme.StatusValid))
+		} else {
+			reply(http.StatusOK, order(acme.StatusReady))
+		}
+	case "/cert/1":
+		w.Header().Set("Content-Type", "application/pem-certificate-chain")
+		w.Write(f.cert)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// requestCert 按 autocert 的流程申请证书：注册账号、创建订单、finalize、等待签发并下载证书
+func requestCert(ctx context.Context, f *fakeACME, transport http.RoundTripper) ([][]byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	client := &acme.Client{
+		Key:          key,
+		DirectoryURL: f.URL + "/dir",
+		HTTPClient:   &http.Client{Timeout: 5 * time.Second, Transport: transport},
+	}
+	if _, err := client.Register(ctx, &acme.Account{}, acme.AcceptTOS); err != nil {
+		return nil, fmt.Errorf("register: %w", err)
+	}
+	order, err := client.AuthorizeOrder(ctx, acme.DomainIDs("example.test"))
+	if err != nil {
+		return nil, fmt.Errorf("order: %w", err)
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{DNSNames: []string{"example.test"}}, key)
+	if err != nil {
+		return nil, err
+	}
+	der, _, err := client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	return der, err
+}
+
+func TestACMEOrderTransport(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	f := newFakeACME(t)
+	transport := &acmeOrderTransport{base: http.DefaultTransport, orders: map[string]string{}}
+	der, err := requestCert(ctx, f, transport)
+	if err != nil {
+		t.Fatalf("申请证书失败: %v", err)
+	}
+	block, _ := pem.Decode(f.cert)
+	if len(der) != 1 || string(der[0]) != string(block.Bytes) {
+		t.Fatalf("下载的证书与签发的不一致")
+	}
+	if f.polled == 0 {
+		t.Fatalf("finalize 后没有查询订单")
+	}
+	if len(transport.orders) != 0 {
+		t.Fatalf("finalize 之后应清除记录的订单: %v", transport.orders)
+	}
+}
+
+// 不补全 Location 时 autocert 无法轮询订单，确认模拟服务复现了这一问题
+func TestACMEWithoutOrderTransport(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	f := newFakeACME(t)
+	_, err := requestCert(ctx, f, http.DefaultTransport)
+	if err == nil {
+		t.Fatal("finalize 响应没有 Location 时应当失败")
+	}
+}
+
+func TestHTTPSMiddlewareTrustedProxies(t *testing.T) {
+	defer SetConfig(*Cfg())
+
+	for _, tc := range []struct {
+		name     string
+		proxies  []string
+		wantCode int
+	}{
+		{"未配置代理时忽略 X-Forwarded-Proto", nil, fiber.StatusMovedPermanently},
+		{"信任的代理", []string{"0.0.0.0"}, fiber.StatusOK},
+		{"其他地址的代理", []string{"10.0.0.1"}, fiber.StatusMovedPermanently},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			SetConfig(Config{HTTPSRedirect: true, HSTSMaxAge: 60, TrustedProxies: tc.proxies})
+			app := fiber.New(withTrustedProxies(fiber.Config{}, tc.proxies))
+			app.Use(HTTPSMiddleware)
+			app.Get("/", func(c *fiber.Ctx) error { return c.SendString("ok") })
+
+			req := httptest.NewRequest(http.MethodGet, "http://example.test/", nil)
+			req.Header.Set(fiber.HeaderXForwardedProto, "https")
+			res, err := app.Test(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.StatusCode != tc.wantCode {
+				t.Fatalf("状态码 %d，期望 %d", res.StatusCode, tc.wantCode)
+			}
+			if hsts := res.Header.Get(fiber.HeaderStrictTransportSecurity); (tc.wantCode == fiber.StatusOK) != (hsts != "") {
+				t.Fatalf("HSTS 头不正确: %q", hsts)
+			}
+		})
+	}
+}
+
+// 反向代理之后按 X-Forwarded-For 区分客户端，只信任配置的代理
+func TestClientIPBehindTrustedProxy(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		proxies   []string
+		forwarded string
+		want      string
+	}{
+		{"代理转发的客户端地址", []string{"0.0.0.0"}, "203.0.113.7", "203.0.113.7"},
+		{"客户端伪造的地址在左边", []string{"0.0.0.0"}, "198.51.100.1, 203.0.113.7", "203.0.113.7"},
+		{"多层代理", []string{"0.0.0.0", "10.0.0.0/8"}, "198.51.100.1, 203.0.113.7, 10.0.0.2", "203.0.113.7"},
+		{"无效的地址", []string{"0.0.0.0"}, "not-an-ip", "0.0.0.0"},
+		{"未配置代理时忽略请求头", nil, "203.0.113.7", "0.0.0.0"},
+		{"其他地址的代理", []string{"10.0.0.1"}, "203.0.113.7", "0.0.0.0"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			app := fiber.New(withTrustedProxies(fiber.Config{}, tc.proxies))
+			app.Use(ClientIPMiddleware)
+			app.Get("/", func(c *fiber.Ctx) error { return c.SendString(c.IP()) })
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set(fiber.HeaderXForwardedFor, tc.forwarded)
+			res, err := app.Test(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := io.ReadAll(res.Body)
+			if string(body) != tc.want {
+				t.Fatalf("客户端地址 %q，期望 %q", body, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/session"
+	"github.com/valyala/fasthttp"
+	"gorm.io/gorm/clause"
+)
+
+// 会话有效期的默认值 (可在 config.json 中修改)
+const (
+	defaultSessionHours = 24
+	defaultRememberDays = 30
+)
+
+const (
+	sessionCookieName    = "session_id"
+	sessionTouchInterval = 5 * time.Minute // 访问页面时顺延过期时间的最小间隔，避免每个请求都写库
+)
+
+// SessionLifetime 返回会话的空闲过期时间，remember 为勾选「记住我」的会话
+func SessionLifetime(remember bool) time.Duration {
+	if remember {
+		days := Cfg().RememberDays
+		if days <= 0 {
+			days = defaultRememberDays
+		}
+		return time.Duration(days) * 24 * time.Hour
+	}
+	hours := Cfg().SessionHours
+	if hours <= 0 {
+		hours = defaultSessionHours
+	}
+	return time.Duration(hours) * time.Hour
+}
+
+// cookieSecure 直接提供 HTTPS 时 Cookie 默认只通过 HTTPS 发送
+func cookieSecure() bool {
+	return Cfg().CookieSecure || TLSEnabled()
+}
+
+// cookieSameSite 返回配置的 SameSite 策略，无效值按 Lax 处理
+func cookieSameSite() string {
+	switch v := strings.ToLower(Cfg().CookieSameSite); v {
+	case "strict", "none":
+		return strings.ToUpper(v[:1]) + v[1:]
+	}
+	return "Lax"
+}
+
+// NewSessionStore 创建会话存储：安装完成后保存在数据库中，重启或切换主题后仍然有效；
+// 安装模式下还没有数据库，使用内存存储
+func NewSessionStore(persistent bool) *session.Store {
+	cfg := session.Config{
+		// 默认为关闭浏览器即失效的会话 Cookie，勾选「记住我」的会话由 SessionCookies 改为持久 Cookie；
+		// 服务端按空闲时间过期
+		Expiration:        SessionLifetime(true),
+		CookieSessionOnly: true,
+		CookieHTTPOnly:    true,
+		CookieSecure:      cookieSecure(),
+		CookieSameSite:    cookieSameSite(),
+	}
+	if persistent {
+		cfg.Storage = dbSessionStorage{}
+	}
+	return session.New(cfg)
+}
+
+// SessionCookies 会话中间件：登录的会话每次访问都顺延空闲过期时间 (包括只读的页面)，
+// 「记住我」的会话使用与服务端过期时间一致的持久 Cookie
+func SessionCookies(c *fiber.Ctx) error {
+	key := c.Cookies(sessionCookieName)
+	renew := key != "" && touchSession(key)
+	err := c.Next()
+
+	// 本次请求保存了会话 (登录、更换会话 ID 等) 时按保存后的会话设置 Cookie，删除会话时不处理
+	if saved := c.Response().Header.PeekCookie(sessionCookieName); len(saved) > 0 {
+		cookie := fasthttp.AcquireCookie()
+		defer fasthttp.ReleaseCookie(cookie)
+		if cookie.ParseBytes(saved) != nil || len(cookie.Value()) == 0 {
+			return err
+		}
+		key, renew = string(cookie.Value()), rememberedSession(string(cookie.Value()))
+	}
+	if renew {
+		lifetime := SessionLifetime(true)
+		c.Cookie(&fiber.Cookie{
+			Name: sessionCookieName, Value: key, Path: "/",
+			MaxAge: int(lifetime.Seconds()), Expires: time.Now().Add(lifetime),
+			Secure: cookieSecure(), HTTPOnly: true, SameSite: cookieSameSite(),
+		})
+	}
+	return err
+}
+
+// touchSession 顺延已登录会话的过期时间，返回是否需要续期「记住我」的 Cookie
+func touchSession(key string) bool {
+	if DB == nil {
+		return false
+	}
+	id := hashToken(key)
+	var s Session
+	if DB.Select("remember", "expires_at").Where("id = ? AND user_id <> 0", id).Limit(1).Find(&s).RowsAffected == 0 || time.Now().After(s.ExpiresAt) {
+		return false
+	}
+	lifetime := SessionLifetime(s.Remember)
+	if time.Until(s.ExpiresAt) > lifetime-sessionTouchInterval {
+		return false
+	}
+	DB.Model(&Session{}).Where("id = ?", id).UpdateColumn("expires_at", time.Now().Add(lifetime))
+	return s.Remember
+}
+
+// rememberedSession 判断会话是否勾选了「记住我」
+func rememberedSession(key string) bool {
+	if DB == nil {
+		return false
+	}
+	var s Session
+	return DB.Select("remember").Where("id = ?", hashToken(key)).Limit(1).Find(&s).RowsAffected > 0 && s.Remember
+}
+
+// dbSessionStorage 基于 GORM 的 fiber.Storage 实现，键为会话 ID 的摘要，数据库泄露时无法直接冒用会话
+type dbSessionStorage struct{}
+
+func (dbSessionStorage) Get(key string) ([]byte, error) {
+	if DB == nil || key == "" {
+		return nil, nil
+	}
+	var s Session
+	res := DB.Select("data", "expires_at").Where("id = ?", hashToken(key)).Limit(1).Find(&s)
+	if res.Error != nil || res.RowsAffected == 0 || time.Now().After(s.ExpiresAt) {
+		return nil, res.Error
+	}
+	return s.Data, nil
+}
+
+// Set 保存会话数据并顺延过期时间；exp 由 Cookie 的有效期决定，这里按会话是否「记住我」重新计算
+func (dbSessionStorage) Set(key string, val []byte, exp time.Duration) error {
+	if DB == nil || key == "" {
+		return nil
+	}
+	id := hashToken(key)
+	var cur Session
+	remember := DB.Select("remember").Where("id = ?", id).Limit(1).Find(&cur).RowsAffected > 0 && cur.Remember
+	row := Session{ID: id, Data: val, ExpiresAt: time.Now().Add(SessionLifetime(remember))}
+	return DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"data", "expires_at", "updated_at"}),
+	}).Create(&row).Error
+}
+
+func (dbSessionStorage) Delete(key string) error {
+	if DB == nil || key == "" {
+		return nil
+	}
+	return DB.Where("id = ?", hashToken(key)).Delete(&Session{}).Error
+}
+
+func (dbSessionStorage) Reset() error {
+	return DB.Where("1 = 1").Delete(&Session{}).Error
+}
+
+func (dbSessionStorage) Close() error { return nil }
+
+// StartUserSession 登录成功后写入会话：更换会话 ID (防止会话固定攻击) 并记录设备信息
+func StartUserSession(c *fiber.Ctx, sess *session.Session, user User, remember bool) error {
+	if err := sess.Regenerate(); err != nil {
+		return err
+	}
+	sess.Set("user_id", user.ID)
+	id := sess.ID()
+	if err := sess.Save(); err != nil {
+		return err
+	}
+	ua := c.Get(fiber.HeaderUserAgent)
+	if len(ua) > 500 {
+		ua = ua[:500]
+	}
+	return DB.Model(&Session{}).Where("id = ?", hashToken(id)).Updates(map[string]interface{}{
+		"user_id": user.ID, "remember": remember, "ip": c.IP(), "user_agent": ua,
+		"expires_at": time.Now().Add(SessionLifetime(remember)),
+	}).Error
+}
+
+// CurrentSessionID 返回当前请求的会话在数据库中的 ID
+func CurrentSessionID(c *fiber.Ctx) string {
+	if key := c.Cookies(sessionCookieName); key != "" {
+		return hashToken(key)
+	}
+	return ""
+}
+
+// ActiveSessions 返回已登录且未过期的会话，userID 为 0 时返回所有用户的会话
+func ActiveSessions(userID uint) []Session {
+	var list []Session
+	query := DB.Omit("data").Where("user_id <> 0 AND expires_at > ?", time.Now())
+	if userID != 0 {
+		query = query.Where("user_id = ?", userID)
+	}
+	query.Order("updated_at desc").Find(&list)
+	return list
+}
+
+// RevokeUserSessions 注销用户的所有会话，except 不为空时保留该会话
+func RevokeUserSessions(userID uint, except string) {
+	DB.Where("user_id = ? AND id <> ?", userID, except).Delete(&Session{})
+}
+
+// PruneSessions 清理过期的会话
+func PruneSessions() {
+	if DB == nil {
+		return
+	}
+	DB.Where("expires_at < ?", time.Now()).Delete(&Session{})
+}
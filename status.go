@@ -0,0 +1,135 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// 文章状态
+const (
+	StatusPublished = "published" // 已发布
+	StatusDraft     = "draft"     // 草稿
+	StatusPending   = "pending"   // 待审核
+	StatusPrivate   = "private"   // 私密 (仅作者与编辑、管理员可见)
+	StatusScheduled = "scheduled" // 定时发布
+)
+
+// PostStatusLabels 状态显示名 (用于后台表单与列表)
+var PostStatusLabels = map[string]string{
+	StatusPublished: "已发布",
+	StatusDraft:     "草稿",
+	StatusPending:   "待审核",
+	StatusPrivate:   "私密",
+	StatusScheduled: "定时发布",
+}
+
+// StatusLabel 返回文章状态的显示名
+func (p Post) StatusLabel() string {
+	if label, ok := PostStatusLabels[p.Status]; ok {
+		return label
+	}
+	return p.Status
+}
+
+// VisibleScope 前台可见性过滤：已发布且到达发布时间；私密内容只对作者与可以编辑他人文章的用户可见
+// 用法: DB.Scopes(VisibleScope(c)).Find(&posts)
+func VisibleScope(c *fiber.Ctx) func(*gorm.DB) *gorm.DB {
+	// 前台请求没有加载用户，按会话中的 ID 查询角色
+	user := currentUser(c)
+	if uid := currentUserID(c); uid != 0 && user.ID != uid {
+		DB.First(&user, uid)
+	}
+	return func(db *gorm.DB) *gorm.DB {
+		now := time.Now()
+		switch {
+		case user.Can(CapEditOthersPosts):
+			return db.Where("((status = ? AND published_at <= ?) OR status = ?)", StatusPublished, now, StatusPrivate)
+		case user.ID != 0:
+			return db.Where("((status = ? AND published_at <= ?) OR (status = ? AND author_id = ?))", StatusPublished, now, StatusPrivate, user.ID)
+		}
+		return db.Where("status = ? AND published_at <= ?", StatusPublished, now)
+	}
+}
+
+// PublicScope 对所有人公开的内容 (Sitemap 等不区分用户的场景)
+func PublicScope(db *gorm.DB) *gorm.DB {
+	return db.Where("status = ? AND published_at <= ?", StatusPublished, time.Now())
+}
+
+// ApplyPostStatus 根据表单提交的状态和发布时间更新文章
+// publishedAt 为 datetime-local 格式 (2006-01-02T15:04) 或 RFC3339 (API)，可为空
+func ApplyPostStatus(post *Post, status, publishedAt string) {
+	if _, ok := PostStatusLabels[status]; !ok {
+		status = StatusPublished
+	}
+
+	var at time.Time
+	if publishedAt != "" {
+		if t, err := time.ParseInLocation("2006-01-02T15:04", publishedAt, time.Local); err == nil {
+			at = t
+		} else if t, err := time.Parse(time.RFC3339, publishedAt); err == nil {
+			at = t
+		}
+	}
+	if !at.IsZero() {
+		post.PublishedAt = at
+	}
+
+	now := time.Now()
+	switch status {
+	case StatusPublished, StatusScheduled:
+		if post.PublishedAt.IsZero() {
+			post.PublishedAt = now
+		}
+		// 发布时间在未来即为定时发布，反之直接发布
+		if post.PublishedAt.After(now) {
+			status = StatusScheduled
+		} else {
+			status = StatusPublished
+		}
+	case StatusPrivate:
+		if post.PublishedAt.IsZero() {
+			post.PublishedAt = now
+		}
+	}
+	post.Status = status
+}
+
+// PublishScheduledPosts 将到达发布时间的定时文章设为已发布
+func PublishScheduledPosts() {
+	if DB == nil {
+		return
+	}
+	res := DB.Model(&Post{}).Where("status = ? AND published_at <= ?", StatusScheduled, time.Now()).Update("status", StatusPublished)
+	if res.Error == nil && res.RowsAffected > 0 {
+		log.Printf("定时发布: %d 篇内容已发布", res.RowsAffected)
+	}
+}
+
+var schedulerOnce sync.Once
+
+// StartScheduler 启动定时发布与过期登录记录、会话、插件数据清理任务 (整个进程只启动一次，重载时不会重复)
+func StartScheduler() {
+	schedulerOnce.Do(func() {
+		go func() {
+			PublishScheduledPosts()
+			PruneLoginAttempts()
+			PruneSessions()
+			PrunePluginData()
+			ticker := time.NewTicker(30 * time.Second)
+			for i := 1; ; i++ {
+				<-ticker.C
+				PublishScheduledPosts()
+				if i%120 == 0 { // 每小时一次
+					PruneLoginAttempts()
+					PruneSessions()
+					PrunePluginData()
+				}
+			}
+		}()
+	})
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Slugify 将名称转换为 URL 友好的 slug (保留中文等非 ASCII 字符)
+func Slugify(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	var b strings.Builder
+	lastDash := false
+	for _, r := range name {
+		switch {
+		case r == ' ' || r == '-' || r == '_' || r == '/' || r == '?' || r == '#' || r == '&' || r == '%':
+			if !lastDash && b.Len() > 0 {
+				b.WriteRune('-')
+				lastDash = true
+			}
+		default:
+			b.WriteRune(r)
+			lastDash = false
+		}
+	}
+	return strings.TrimSuffix(b.String(), "-")
+}
+
+// GetCategoryTree 按树形顺序返回所有分类，并填充 Depth
+func GetCategoryTree() []Category {
+	var all []Category
+	if DB == nil {
+		return all
+	}
+	DB.Order("name asc").Find(&all)
+
+	children := make(map[uint][]Category)
+	ids := make(map[uint]bool)
+	for _, cat := range all {
+		ids[cat.ID] = true
+	}
+	for _, cat := range all {
+		parent := cat.ParentID
+		// 父分类不存在时当作顶级分类处理
+		if !ids[parent] {
+			parent = 0
+		}
+		children[parent] = append(children[parent], cat)
+	}
+
+	var tree []Category
+	var walk func(parent uint, depth int)
+	walk = func(parent uint, depth int) {
+		for _, cat := range children[parent] {
+			cat.Depth = depth
+			tree = append(tree, cat)
+			walk(cat.ID, depth+1)
+		}
+	}
+	walk(0, 0)
+	return tree
+}
+
+// Indent 返回树形展示用的缩进前缀
+func (c Category) Indent() string {
+	return strings.Repeat("— ", c.Depth)
+}
+
+// CategoryDescendantIDs 返回分类自身及其所有子孙分类的 ID
+func CategoryDescendantIDs(id uint) []uint {
+	var all []Category
+	DB.Select("id", "parent_id").Find(&all)
+
+	children := make(map[uint][]uint)
+	for _, cat := range all {
+		children[cat.ParentID] = append(children[cat.ParentID], cat.ID)
+	}
+
+	result := []uint{id}
+	seen := map[uint]bool{id: true}
+	for i := 0; i < len(result); i++ {
+		for _, child := range children[result[i]] {
+			if !seen[child] {
+				seen[child] = true
+				result = append(result, child)
+			}
+		}
+	}
+	return result
+}
+
+// FindOrCreateTags 解析逗号分隔的标签字符串，不存在的标签会被自动创建
+func FindOrCreateTags(input string) []Tag {
+	var tags []Tag
+	seen := make(map[string]bool)
+	input = strings.ReplaceAll(input, "，", ",")
+	for _, name := range strings.Split(input, ",") {
+		name = strings.TrimSpace(name)
+		slug := Slugify(name)
+		if slug == "" || seen[slug] {
+			continue
+		}
+		seen[slug] = true
+
+		var tag Tag
+		if err := DB.Where("slug = ?", slug).First(&tag).Error; err != nil {
+			tag = Tag{Name: name, Slug: slug}
+			if err := DB.Create(&tag).Error; err != nil {
+				continue
+			}
+		}
+		tags = append(tags, tag)
+	}
+	return tags
+}
+
+// TagNames 将标签列表拼接为逗号分隔的字符串 (用于编辑表单回填)
+func TagNames(tags []Tag) string {
+	names := make([]string, 0, len(tags))
+	for _, t := range tags {
+		names = append(names, t.Name)
+	}
+	return strings.Join(names, ", ")
+}
+
+// formValues 读取表单中的多值字段 (如多选框)
+func formValues(c *fiber.Ctx, key string) []string {
+	if form, err := c.MultipartForm(); err == nil {
+		return form.Value[key]
+	}
+	var values []string
+	for _, v := range c.Request().PostArgs().PeekMulti(key) {
+		values = append(values, string(v))
+	}
+	return values
+}
+
+// SavePostTaxonomy 根据表单内容更新文章的分类和标签
+func SavePostTaxonomy(c *fiber.Ctx, post *Post) {
+	SetPostTaxonomy(post, FormCategoryIDs(c), c.FormValue("tags"))
+}
+
+// FormCategoryIDs 读取表单中勾选的分类 ID
+func FormCategoryIDs(c *fiber.Ctx) []uint {
+	var ids []uint
+	for _, v := range formValues(c, "category_ids") {
+		if id, err := strconv.ParseUint(v, 10, 64); err == nil {
+			ids = append(ids, uint(id))
+		}
+	}
+	return ids
+}
+
+// SetPostTaxonomy 替换文章的分类与标签，tags 为逗号分隔的标签名 (页面没有分类和标签)
+func SetPostTaxonomy(post *Post, categoryIDs []uint, tags string) {
+	if post.Type == "page" {
+		return
+	}
+
+	var categories []Category
+	if len(categoryIDs) > 0 {
+		DB.Where("id IN ?", categoryIDs).Find(&categories)
+	}
+	if len(categories) > 0 {
+		DB.Model(post).Association("Categories").Replace(categories)
+	} else {
+		DB.Model(post).Association("Categories").Clear()
+	}
+
+	if tags := FindOrCreateTags(tags); len(tags) > 0 {
+		DB.Model(post).Association("Tags").Replace(tags)
+	} else {
+		DB.Model(post).Association("Tags").Clear()
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/template/html/v2"
+)
+
+// themeWatchInterval 检查模板文件是否被修改的间隔
+const themeWatchInterval = 2 * time.Second
+
+// Theme 某一时刻的主题快照，请求内使用同一份快照渲染，切换主题不影响进行中的请求
+type Theme struct {
+	ID     string
+	Dir    string
+	Config ThemeConfig
+}
+
+// Template 返回主题中第一个存在的模板 (如 archive 不存在时回退到 index)
+func (t Theme) Template(names ...string) string {
+	for _, name := range names[:len(names)-1] {
+		if _, err := os.Stat(t.Dir + "/" + name + ".html"); err == nil {
+			return t.Dir + "/" + name
+		}
+	}
+	return t.Dir + "/" + names[len(names)-1]
+}
+
+// Layout 返回主题的布局模板
+func (t Theme) Layout() string {
+	return t.Dir + "/layout"
+}
+
+// ThemeRegistry 当前主题与模板引擎的注册表，启用主题、上传主题或修改模板后原地替换，无需重启应用
+// 同时实现 fiber.Views，所有渲染都委托给当前的模板引擎
+type ThemeRegistry struct {
+	mu     sync.RWMutex
+	theme  Theme
+	engine *html.Engine
+	stamp  string // 模板文件的指纹，用于检测修改
+}
+
+// Themes 全局主题注册表
+var Themes = &ThemeRegistry{}
+
+// Current 返回当前主题的快照
+func (r *ThemeRegistry) Current() Theme {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.theme
+}
+
+// ID 返回当前主题的 ID
+func (r *ThemeRegistry) ID() string {
+	return r.Current().ID
+}
+
+// Activate 加载主题及全部模板，成功后替换当前主题；模板有错误时保留原来的主题
+func (r *ThemeRegistry) Activate(id string) error {
+	if id == "" {
+		id = "default"
+	}
+	if id != filepath.Base(id) || id == "." || id == ".." {
+		return inputError("主题不存在")
+	}
+	dir := "themes/" + id
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return inputError("主题不存在")
+	}
+	stamp := themeStamp(dir)
+	engine := newViewEngine()
+	if err := engine.Load(); err != nil {
+		return fmt.Errorf("模板加载失败: %w", err)
+	}
+	// id 可能来自请求参数，Fiber 会复用请求的内存，长期保存前需要复制
+	id = strings.Clone(id)
+	theme := Theme{ID: id, Dir: dir, Config: loadThemeConfig(dir)}
+
+	r.mu.Lock()
+	r.theme, r.engine, r.stamp = theme, engine, stamp
+	r.mu.Unlock()
+	return nil
+}
+
+// Reload 重新加载当前主题 (配置或模板文件有变化时调用)
+func (r *ThemeRegistry) Reload() error {
+	return r.Activate(r.ID())
+}
+
+// Load 实现 fiber.Views；模板在 Activate 时已经加载
+func (r *ThemeRegistry) Load() error {
+	return nil
+}
+
+// Render 实现 fiber.Views
+func (r *ThemeRegistry) Render(w io.Writer, name string, bind interface{}, layout ...string) error {
+	r.mu.RLock()
+	engine := r.engine
+	r.mu.RUnlock()
+	if engine == nil {
+		return errors.New("主题尚未加载")
+	}
+	return engine.Render(w, name, bind, layout...)
+}
+
+var themeWatchOnce sync.Once
+
+// Watch 定期检查当前主题与后台模板，文件被修改后自动重新加载 (整个进程只启动一次)
+func (r *ThemeRegistry) Watch() {
+	themeWatchOnce.Do(func() {
+		go func() {
+			for range time.Tick(themeWatchInterval) {
+				r.mu.RLock()
+				dir, stamp := r.theme.Dir, r.stamp
+				r.mu.RUnlock()
+				if dir == "" || themeStamp(dir) == stamp {
+					continue
+				}
+				if err := r.Reload(); err != nil {
+					log.Println("主题重新加载失败:", err)
+					// 记下新的指纹，文件再次修改前不重复尝试
+					r.mu.Lock()
+					r.stamp = themeStamp(dir)
+					r.mu.Unlock()
+					continue
+				}
+				log.Println("主题模板已重新加载:", r.ID())
+			}
+		}()
+	})
+}
+
+// themeStamp 计算主题目录与后台模板的指纹 (文件数、总大小与最后修改时间)
+func themeStamp(dirs ...string) string {
+	var count, size int64
+	var latest time.Time
+	for _, dir := range append(dirs, "views") {
+		filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() || (filepath.Ext(path) != ".html" && d.Name() != "config.json") {
+				return nil
+			}
+			if info, err := d.Info(); err == nil {
+				count++
+				size += info.Size()
+				if info.ModTime().After(latest) {
+					latest = info.ModTime()
+				}
+			}
+			return nil
+		})
+	}
+	return fmt.Sprintf("%d/%d/%d", count, size, latest.UnixNano())
+}
+
+// loadThemeConfig 读取主题的 config.json 并生成扁平化配置
+func loadThemeConfig(dir string) ThemeConfig {
+	var config ThemeConfig
+	raw, err := os.ReadFile(dir + "/config.json")
+	if err != nil || json.Unmarshal(raw, &config) != nil {
+		// 读取失败时的默认值
+		config = ThemeConfig{Name: "Default", Author: "Admin", Description: "Fallback theme"}
+	}
+	config.Config = make(map[string]string)
+	for _, s := range config.Settings {
+		val := s.Value
+		if val == "" {
+			val = s.Default
+		}
+		config.Config[s.Key] = val
+	}
+	return config
+}
+
+// newViewEngine 创建模板引擎并注册模板函数
+func newViewEngine() *html.Engine {
+	engine := html.New(".", ".html")
+	engine.AddFunc("safe", func(content string) template.HTML {
+		return template.HTML(content)
+	})
+	engine.AddFunc("markdown", RenderMarkdown)
+	engine.AddFunc("summary", RenderSummary)
+	return engine
+}
+
+// ServeThemeStatic 从当前主题的 static 目录提供静态文件
+func ServeThemeStatic(c *fiber.Ctx) error {
+	name := filepath.Clean("/" + c.Params("*"))
+	return c.SendFile(Themes.Current().Dir + "/static" + name)
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// 令牌权限范围
+const (
+	ScopeRead  = "read"  // 读取 API
+	ScopeWrite = "write" // 通过 API 创建、修改、删除内容 (包含 read)
+	ScopeAdmin = "admin" // 调用后台接口，如上传插件、上传媒体
+)
+
+// TokenScopeLabels 后台展示用的权限范围
+var TokenScopeLabels = []struct{ Value, Label string }{
+	{ScopeRead, "读取 API"},
+	{ScopeWrite, "写入 API"},
+	{ScopeAdmin, "后台接口"},
+}
+
+// tokenPrefix 令牌明文前缀，便于在日志或代码仓库中识别泄露的令牌
+const tokenPrefix = "gp_"
+
+// 最后使用时间的更新间隔，避免每个请求都写库
+const tokenTouchInterval = time.Minute
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// HasScope 判断令牌是否拥有某项权限范围
+func (t APIToken) HasScope(scope string) bool {
+	for _, s := range strings.Split(t.Scopes, ",") {
+		if s == scope || (s == ScopeWrite && scope == ScopeRead) {
+			return true
+		}
+	}
+	return false
+}
+
+// ScopeList 返回权限范围列表 (模板用)
+func (t APIToken) ScopeList() []string {
+	if t.Scopes == "" {
+		return nil
+	}
+	return strings.Split(t.Scopes, ",")
+}
+
+// Expired 判断令牌是否已过期
+func (t APIToken) Expired() bool {
+	return t.ExpiresAt != nil && time.Now().After(*t.ExpiresAt)
+}
+
+// CreateAPIToken 为用户生成令牌，返回的明文只在此时可见
+func CreateAPIToken(userID uint, name string, scopes []string, expiresIn time.Duration) (*APIToken, string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, "", inputError("请填写令牌名称")
+	}
+	var valid []string
+	for _, s := range TokenScopeLabels {
+		for _, want := range scopes {
+			if want == s.Value {
+				valid = append(valid, s.Value)
+				break
+			}
+		}
+	}
+	if len(valid) == 0 {
+		return nil, "", inputError("请至少选择一项权限")
+	}
+
+	buf := make([]byte, 20)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, "", err
+	}
+	plain := tokenPrefix + hex.EncodeToString(buf)
+	tok := &APIToken{
+		UserID:    userID,
+		Name:      name,
+		Prefix:    plain[:len(tokenPrefix)+6],
+		TokenHash: hashToken(plain),
+		Scopes:    strings.Join(valid, ","),
+	}
+	if expiresIn > 0 {
+		t := time.Now().Add(expiresIn)
+		tok.ExpiresAt = &t
+	}
+	if err := DB.Create(tok).Error; err != nil {
+		return nil, "", err
+	}
+	return tok, plain, nil
+}
+
+// bearerToken 读取 Authorization: Bearer 头
+func bearerToken(c *fiber.Ctx) string {
+	auth := c.Get(fiber.HeaderAuthorization)
+	if len(auth) > 7 && strings.EqualFold(auth[:7], "bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	return ""
+}
+
+// requiredScope 请求所需的权限范围：后台接口需要 admin，API 的读操作需要 read，其余需要 write
+func requiredScope(c *fiber.Ctx) string {
+	if strings.HasPrefix(c.Path(), "/admin") {
+		return ScopeAdmin
+	}
+	if c.Method() == fiber.MethodGet || c.Method() == fiber.MethodHead {
+		return ScopeRead
+	}
+	return ScopeWrite
+}
+
+// TokenAuth 接受 Authorization: Bearer 令牌的中间件
+// 没有携带令牌时交给会话鉴权；令牌无效、过期或权限范围不足时直接返回 401 / 403
+func TokenAuth(c *fiber.Ctx) error {
+	plain := bearerToken(c)
+	if plain == "" {
+		return c.Next()
+	}
+	unauthorized := func(msg string) error {
+		c.Set(fiber.HeaderWWWAuthenticate, `Bearer realm="gopress"`)
+		return c.Status(401).JSON(fiber.Map{"error": msg})
+	}
+
+	var tok APIToken
+	if err := DB.Where("token_hash = ?", hashToken(plain)).First(&tok).Error; err != nil {
+		return unauthorized("无效的令牌")
+	}
+	if tok.Expired() {
+		return unauthorized("令牌已过期")
+	}
+	var user User
+	if err := DB.First(&user, tok.UserID).Error; err != nil {
+		return unauthorized("无效的令牌")
+	}
+	if !tok.HasScope(requiredScope(c)) {
+		return c.Status(403).JSON(fiber.Map{"error": "令牌缺少 " + requiredScope(c) + " 权限"})
+	}
+
+	if tok.LastUsedAt == nil || time.Since(*tok.LastUsedAt) > tokenTouchInterval {
+		DB.Model(&tok).UpdateColumn("last_used_at", time.Now())
+	}
+	c.Locals("user", user)
+	c.Locals("token", tok)
+	return c.Next()
+}
+
+// SessionOnly 拒绝 API 令牌访问的中间件，用于令牌管理、密码与两步验证等账号凭据相关的接口，
+// 避免泄露的令牌被用来签发新令牌或接管账号
+func SessionOnly(c *fiber.Ctx) error {
+	if c.Locals("token") != nil {
+		return c.Status(403).JSON(fiber.Map{"error": ErrSessionRequired.Error()})
+	}
+	return c.Next()
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+// TOTP 参数 (RFC 6238，与 Google Authenticator 等应用的默认值一致)
+const (
+	totpPeriod = 30 // 时间步长 (秒)
+	totpDigits = 6
+	totpSkew   = 1 // 允许前后各一个时间步的时钟偏差
+
+	recoveryCodeCount = 10
+	twoFactorLoginTTL = 5 * time.Minute // 输入密码后完成第二步的时限
+	twoFactorMaxTries = 5               // 第二步允许的错误次数
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// sealedPrefix 加密后的密钥前缀，没有前缀的是升级前保存的明文密钥
+const sealedPrefix = "enc:"
+
+// NewSecretKey 生成 256 位的主密钥
+func NewSecretKey() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(buf), nil
+}
+
+// secretCipher 由配置中的主密钥派生 AES-256-GCM
+func secretCipher() (cipher.AEAD, error) {
+	secret := Cfg().SecretKey
+	if secret == "" {
+		return nil, errors.New("未配置 secret_key")
+	}
+	key := sha256.Sum256([]byte(secret))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealSecret 加密保存到数据库的密钥
+func sealSecret(plain string) (string, error) {
+	aead, err := secretCipher()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return sealedPrefix + base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, []byte(plain), nil)), nil
+}
+
+// openSecret 解密 sealSecret 的结果，明文密钥原样返回
+func openSecret(stored string) (string, error) {
+	data, ok := strings.CutPrefix(stored, sealedPrefix)
+	if !ok {
+		return stored, nil
+	}
+	aead, err := secretCipher()
+	if err != nil {
+		return "", err
+	}
+	raw, err := base64.StdEncoding.DecodeString(data)
+	if err != nil || len(raw) < aead.NonceSize() {
+		return "", errors.New("密钥格式无效")
+	}
+	plain, err := aead.Open(nil, raw[:aead.NonceSize()], raw[aead.NonceSize():], nil)
+	if err != nil {
+		return "", errors.New("密钥解密失败，secret_key 可能已更换")
+	}
+	return string(plain), nil
+}
+
+// InitSecretKey 缺少主密钥时生成并写入 config.json，再加密升级前以明文保存的两步验证密钥
+func InitSecretKey() error {
+	if Cfg().SecretKey == "" {
+		key, err := NewSecretKey()
+		if err != nil {
+			return err
+		}
+		cfg := *Cfg()
+		cfg.SecretKey = key
+		if err := SaveConfig(cfg); err != nil {
+			return err
+		}
+		SetConfig(cfg)
+	}
+	var users []User
+	DB.Where("totp_secret <> ? AND totp_secret NOT LIKE ?", "", sealedPrefix+"%").Find(&users)
+	for _, u := range users {
+		sealed, err := sealSecret(u.TOTPSecret)
+		if err != nil {
+			return err
+		}
+		DB.Model(&u).UpdateColumn("totp_secret", sealed)
+	}
+	if len(users) > 0 {
+		log.Printf("已加密 %d 个用户的两步验证密钥", len(users))
+	}
+	return nil
+}
+
+// NewTOTPSecret 生成 160 位的随机密钥
+func NewTOTPSecret() (string, error) {
+	buf := make([]byte, 20)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(buf), nil
+}
+
+// totpCode 计算某个时间步的验证码 (RFC 4226 HOTP)
+func totpCode(key []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	n := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, n%1000000)
+}
+
+// ValidateTOTP 校验验证码，返回匹配的时间步；lastStep 及之前的时间步不再接受
+func ValidateTOTP(secret, code string, lastStep int64) (int64, bool) {
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if len(code) != totpDigits {
+		return 0, false
+	}
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return 0, false
+	}
+	now := time.Now().Unix() / totpPeriod
+	for step := now - totpSkew; step <= now+totpSkew; step++ {
+		if step > lastStep && hmac.Equal([]byte(totpCode(key, step)), []byte(code)) {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// TOTPURI 返回验证器应用识别的 otpauth:// 地址
+func TOTPURI(secret, account string) string {
+	issuer := SiteSettings()["site_title"]
+	if issuer == "" {
+		issuer = "GoPress"
+	}
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	v.Set("digits", fmt.Sprint(totpDigits))
+	v.Set("period", fmt.Sprint(totpPeriod))
+	return "otpauth://totp/" + url.PathEscape(issuer+":"+account) + "?" + v.Encode()
+}
+
+// TOTPQRCode 在服务端生成二维码，返回可直接用于 <img src> 的 data URI
+func TOTPQRCode(uri string) (string, error) {
+	png, err := qrcode.Encode(uri, qrcode.Medium, 220)
+	if err != nil {
+		return "", err
+	}
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(png), nil
+}
+
+// FormatSecret 每 4 个字符分组，便于手动输入
+func FormatSecret(secret string) string {
+	var b strings.Builder
+	for i, r := range secret {
+		if i > 0 && i%4 == 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// normalizeRecoveryCode 忽略大小写、空格与连字符
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	return strings.NewReplacer(" ", "", "-", "").Replace(strings.TrimSpace(code))
+}
+
+// GenerateRecoveryCodes 作废旧的恢复码并生成一组新的，返回明文 (只显示一次)
+func GenerateRecoveryCodes(userID uint) ([]string, error) {
+	const alphabet = "abcdefghjkmnpqrstuvwxyz23456789" // 去掉易混淆的字符
+	codes := make([]string, 0, recoveryCodeCount)
+	rows := make([]RecoveryCode, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		buf := make([]byte, 10)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, err
+		}
+		for j := range buf {
+			buf[j] = alphabet[int(buf[j])%len(alphabet)]
+		}
+		code := string(buf[:5]) + "-" + string(buf[5:])
+		codes = append(codes, code)
+		rows = append(rows, RecoveryCode{UserID: userID, CodeHash: hashToken(normalizeRecoveryCode(code))})
+	}
+	DB.Unscoped().Where("user_id = ?", userID).Delete(&RecoveryCode{})
+	if err := DB.Create(&rows).Error; err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// RecoveryCodesLeft 返回未使用的恢复码数量
+func RecoveryCodesLeft(userID uint) int64 {
+	var n int64
+	DB.Model(&RecoveryCode{}).Where("user_id = ? AND used_at IS NULL", userID).Count(&n)
+	return n
+}
+
+// VerifySecondFactor 校验验证码或恢复码，通过后记录时间步 / 标记恢复码已使用
+// 时间步只在比已记录的更新时写入，同一验证码的并发请求只有一个能通过
+func VerifySecondFactor(user *User, code string) bool {
+	secret, err := openSecret(user.TOTPSecret)
+	if err != nil {
+		log.Printf("用户 %s 的两步验证密钥无法解密: %v", user.Username, err)
+	}
+	if step, ok := ValidateTOTP(secret, code, user.TOTPLastStep); ok && err == nil {
+		res := DB.Model(&User{}).Where("id = ? AND totp_last_step < ?", user.ID, step).UpdateColumn("totp_last_step", step)
+		if res.Error != nil || res.RowsAffected != 1 {
+			return false
+		}
+		user.TOTPLastStep = step
+		return true
+	}
+	hash := hashToken(normalizeRecoveryCode(code))
+	res := DB.Model(&RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", user.ID, hash).
+		Update("used_at", time.Now())
+	return res.Error == nil && res.RowsAffected == 1
+}
+
+// EnableTwoFactor 校验首个验证码后为用户启用两步验证，返回恢复码
+func EnableTwoFactor(user *User, secret, code string) ([]string, error) {
+	step, ok := ValidateTOTP(secret, code, 0)
+	if !ok {
+		return nil, inputError("验证码不正确，请确认手机时间准确后重试")
+	}
+	sealed, err := sealSecret(secret)
+	if err != nil {
+		return nil, err
+	}
+	user.TOTPSecret, user.TOTPEnabled, user.TOTPLastStep = sealed, true, step
+	if err := DB.Model(user).Select("totp_secret", "totp_enabled", "totp_last_step").Updates(user).Error; err != nil {
+		return nil, err
+	}
+	return GenerateRecoveryCodes(user.ID)
+}
+
+// DisableTwoFactor 关闭两步验证并删除恢复码
+func DisableTwoFactor(user *User) error {
+	user.TOTPSecret, user.TOTPEnabled, user.TOTPLastStep = "", false, 0
+	DB.Unscoped().Where("user_id = ?", user.ID).Delete(&RecoveryCode{})
+	return DB.Model(user).Select("totp_secret", "totp_enabled", "totp_last_step").Updates(user).Error
+}
+
+// TwoFactorRequired 判断站点设置是否要求该用户的角色启用两步验证
+func TwoFactorRequired(user User) bool {
+	for _, role := range strings.Split(SiteSettings()["require_2fa_roles"], ",") {
+		if role != "" && role == user.Role {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// revokeSessionsAfterReset 密码被重置后注销该用户的登录会话；修改自己的密码时保留当前会话
+func revokeSessionsAfterReset(c *fiber.Ctx, user User) {
+	except := ""
+	if user.ID == currentUserID(c) {
+		except = CurrentSessionID(c)
+	}
+	RevokeUserSessions(user.ID, except)
+}
+
+// ErrSessionRequired 修改密码等账号凭据的操作不接受 API 令牌
+var ErrSessionRequired = errors.New("该接口不接受 API 令牌，请登录后台操作")
+
+// CheckPasswordChange 校验当前请求能否修改 target 的密码 (后台与 API 共用)：
+// 不接受 API 令牌，修改自己的密码时需要提供当前密码
+func CheckPasswordChange(c *fiber.Ctx, target User, currentPassword string) error {
+	if c.Locals("token") != nil {
+		return ErrSessionRequired
+	}
+	if target.ID != 0 && target.ID == currentUserID(c) && !CheckPasswordHash(currentPassword, target.Password) {
+		return inputError("当前密码不正确")
+	}
+	return nil
+}
+
+// UserNames 返回用户 ID 到显示名的映射 (后台列表展示作者用)
+func UserNames() map[uint]string {
+	var users []User
+	DB.Select("id", "username", "nickname").Find(&users)
+	names := make(map[uint]string, len(users))
+	for _, u := range users {
+		names[u.ID] = u.DisplayName()
+	}
+	return names
+}
+
+// countAdmins 统计管理员数量，excludeID 不为 0 时排除该用户
+func countAdmins(excludeID uint) int64 {
+	var n int64
+	query := DB.Model(&User{}).Where("role = ?", RoleAdmin)
+	if excludeID != 0 {
+		query = query.Where("id <> ?", excludeID)
+	}
+	query.Count(&n)
+	return n
+}
+
+// SaveUser 创建或更新用户，password 为空时保留原密码
+func SaveUser(user *User, username, password, nickname, role string) error {
+	username = strings.TrimSpace(username)
+	if user.ID == 0 {
+		if username == "" {
+			return inputError("请填写用户名")
+		}
+		if password == "" {
+			return inputError("请设置密码")
+		}
+		var exists int64
+		DB.Model(&User{}).Where("username = ?", username).Count(&exists)
+		if exists > 0 {
+			return inputError("用户名已存在")
+		}
+		user.Username = username
+	}
+	if !IsValidRole(role) {
+		return inputError("无效的角色")
+	}
+	// 至少保留一个管理员
+	if user.ID != 0 && user.Role == RoleAdmin && role != RoleAdmin && countAdmins(user.ID) == 0 {
+		return inputError("至少需要保留一个管理员")
+	}
+	if password != "" {
+		if len(password) < 8 {
+			return inputError("密码至少 8 位")
+		}
+		hash, err := HashPassword(password)
+		if err != nil {
+			return err
+		}
+		user.Password = hash
+	}
+	user.Nickname = strings.TrimSpace(nickname)
+	user.Role = role
+	return DB.Save(user).Error
+}
+
+// DeleteUser 删除用户，其文章转给 heir，API 令牌与登录会话随之作废
+func DeleteUser(user User, heir uint) error {
+	if user.Role == RoleAdmin && countAdmins(user.ID) == 0 {
+		return inputError("至少需要保留一个管理员")
+	}
+	DB.Model(&Post{}).Where("author_id = ?", user.ID).Update("author_id", heir)
+	DB.Unscoped().Where("user_id = ?", user.ID).Delete(&APIToken{})
+	RevokeUserSessions(user.ID, "")
+	return DB.Unscoped().Delete(&user).Error
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"container/heap"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/draw"
+	"io"
+)
+
+// ==========================================
+// 纯 Go 无损 WebP (VP8L) 编码器
+// ==========================================
+// 只使用减绿变换和最基础的前缀编码 (不做颜色缓存与反向引用)，
+// 压缩率不如 libwebp，但不依赖 CGO，适合生成缩略图等小尺寸变体。
+
+// VP8L 规范中码长码的写入顺序
+var codeLengthCodeOrder = [19]int{17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+type bitWriter struct {
+	buf []byte
+	acc uint64
+	n   uint
+}
+
+// write 以 LSB 优先的顺序写入 v 的低 n 位
+func (w *bitWriter) write(v uint32, n uint) {
+	w.acc |= uint64(v&(1<<n-1)) << w.n
+	w.n += n
+	for w.n >= 8 {
+		w.buf = append(w.buf, byte(w.acc))
+		w.acc >>= 8
+		w.n -= 8
+	}
+}
+
+func (w *bitWriter) flush() {
+	if w.n > 0 {
+		w.buf = append(w.buf, byte(w.acc))
+		w.acc, w.n = 0, 0
+	}
+}
+
+// prefixCode 单个符号的前缀码 (已按写入顺序反转)
+type prefixCode struct {
+	bits uint32
+	len  uint
+}
+
+// EncodeWebP 将图像编码为无损 WebP
+func EncodeWebP(w io.Writer, img image.Image) error {
+	b := img.Bounds()
+	width, height := b.Dx(), b.Dy()
+	if width < 1 || height < 1 || width > 1<<14 || height > 1<<14 {
+		return errors.New("webp: 图像尺寸超出范围")
+	}
+
+	nrgba := image.NewNRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(nrgba, nrgba.Bounds(), img, b.Min, draw.Src)
+
+	// 减绿变换：红、蓝通道减去绿色分量，照片类图像的直方图会更集中
+	pix := nrgba.Pix
+	for i := 0; i < len(pix); i += 4 {
+		pix[i] -= pix[i+1]
+		pix[i+2] -= pix[i+1]
+	}
+
+	// 统计各通道的直方图，绿色通道的字母表包含 24 个长度前缀码
+	green, red, blue, alpha := make([]int, 256+24), make([]int, 256), make([]int, 256), make([]int, 256)
+	hasAlpha := false
+	for i := 0; i < len(pix); i += 4 {
+		red[pix[i]]++
+		green[pix[i+1]]++
+		blue[pix[i+2]]++
+		alpha[pix[i+3]]++
+		if pix[i+3] != 0xff {
+			hasAlpha = true
+		}
+	}
+
+	bw := &bitWriter{}
+	bw.write(0x2f, 8) // VP8L 签名
+	bw.write(uint32(width-1), 14)
+	bw.write(uint32(height-1), 14)
+	if hasAlpha {
+		bw.write(1, 1)
+	} else {
+		bw.write(0, 1)
+	}
+	bw.write(0, 3) // 版本号
+	bw.write(1, 1) // 变换: 减绿 (SUBTRACT_GREEN = 2)
+	bw.write(2, 2)
+	bw.write(0, 1) // 变换结束
+	bw.write(0, 1) // 无颜色缓存
+	bw.write(0, 1) // 无元前缀码
+
+	greenCodes := writePrefixCode(bw, green)
+	redCodes := writePrefixCode(bw, red)
+	blueCodes := writePrefixCode(bw, blue)
+	alphaCodes := writePrefixCode(bw, alpha)
+	writePrefixCode(bw, make([]int, 40)) // 距离码 (未使用)
+
+	for i := 0; i < len(pix); i += 4 {
+		c := greenCodes[pix[i+1]]
+		bw.write(c.bits, c.len)
+		c = redCodes[pix[i]]
+		bw.write(c.bits, c.len)
+		c = blueCodes[pix[i+2]]
+		bw.write(c.bits, c.len)
+		c = alphaCodes[pix[i+3]]
+		bw.write(c.bits, c.len)
+	}
+	bw.flush()
+
+	// RIFF 容器
+	data := bw.buf
+	padded := len(data) + len(data)%2
+	header := make([]byte, 20)
+	copy(header[0:], "RIFF")
+	binary.LittleEndian.PutUint32(header[4:], uint32(4+8+padded))
+	copy(header[8:], "WEBPVP8L")
+	binary.LittleEndian.PutUint32(header[16:], uint32(len(data)))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if padded != len(data) {
+		_, err := w.Write([]byte{0})
+		return err
+	}
+	return nil
+}
+
+// writePrefixCode 根据直方图写入一个前缀码，并返回各符号的编码
+func writePrefixCode(bw *bitWriter, counts []int) []prefixCode {
+	codes := make([]prefixCode, len(counts))
+
+	var used []int
+	for sym, c := range counts {
+		if c > 0 {
+			used = append(used, sym)
+		}
+	}
+
+	// 只有一个 (或没有) 符号时使用 "简单码"，该符号占 0 位
+	if len(used) <= 1 {
+		sym := 0
+		if len(used) == 1 {
+			sym = used[0]
+		}
+		bw.write(1, 1) // 简单码
+		bw.write(0, 1) // 符号数 - 1
+		if sym < 2 {
+			bw.write(0, 1)
+			bw.write(uint32(sym), 1)
+		} else {
+			bw.write(1, 1)
+			bw.write(uint32(sym), 8)
+		}
+		return codes
+	}
+
+	lengths := huffmanLengths(counts, 15)
+
+	// 码长本身也用前缀码编码 (码长码)，至少保证两个符号以免出现 0 位码
+	clCounts := make([]int, 19)
+	for _, l := range lengths {
+		clCounts[l]++
+	}
+	nonZero := 0
+	for _, c := range clCounts {
+		if c > 0 {
+			nonZero++
+		}
+	}
+	if nonZero < 2 {
+		if clCounts[0] == 0 {
+			clCounts[0] = 1
+		} else {
+			clCounts[1] = 1
+		}
+	}
+	clLengths := huffmanLengths(clCounts, 7)
+	clCodes := canonicalCodes(clLengths)
+
+	bw.write(0, 1) // 普通码
+	numCodes := 4
+	for i := len(codeLengthCodeOrder) - 1; i >= 4; i-- {
+		if clLengths[codeLengthCodeOrder[i]] > 0 {
+			numCodes = i + 1
+			break
+		}
+	}
+	bw.write(uint32(numCodes-4), 4)
+	for i := 0; i < numCodes; i++ {
+		bw.write(uint32(clLengths[codeLengthCodeOrder[i]]), 3)
+	}
+	bw.write(0, 1) // max_symbol 使用整个字母表
+	for _, l := range lengths {
+		c := clCodes[l]
+		bw.write(c.bits, c.len)
+	}
+
+	return canonicalCodes(lengths)
+}
+
+// canonicalCodes 由码长生成规范前缀码 (与 DEFLATE 相同的分配方式)
+func canonicalCodes(lengths []uint8) []prefixCode {
+	var blCount [16]uint32
+	for _, l := range lengths {
+		if l > 0 {
+			blCount[l]++
+		}
+	}
+	var nextCode [16]uint32
+	code := uint32(0)
+	for bits := 1; bits < 16; bits++ {
+		code = (code + blCount[bits-1]) << 1
+		nextCode[bits] = code
+	}
+
+	codes := make([]prefixCode, len(lengths))
+	for sym, l := range lengths {
+		if l == 0 {
+			continue
+		}
+		c := nextCode[l]
+		nextCode[l]++
+		// 前缀码按最高位优先读取，而位流是 LSB 优先，需要反转
+		rev := uint32(0)
+		for i := uint8(0); i < l; i++ {
+			rev = rev<<1 | (c>>i)&1
+		}
+		codes[sym] = prefixCode{bits: rev, len: uint(l)}
+	}
+	return codes
+}
+
+type huffNode struct {
+	count       int
+	sym         int // 叶子节点的符号，内部节点为 -1
+	left, right int
+}
+
+type huffHeap struct {
+	nodes []huffNode
+	idx   []int
+}
+
+func (h huffHeap) Len() int { return len(h.idx) }
+func (h huffHeap) Less(i, j int) bool {
+	a, b := h.nodes[h.idx[i]], h.nodes[h.idx[j]]
+	if a.count != b.count {
+		return a.count < b.count
+	}
+	return h.idx[i] < h.idx[j]
+}
+func (h huffHeap) Swap(i, j int) { h.idx[i], h.idx[j] = h.idx[j], h.idx[i] }
+func (h *huffHeap) Push(x any)   { h.idx = append(h.idx, x.(int)) }
+func (h *huffHeap) Pop() any {
+	n := len(h.idx)
+	x := h.idx[n-1]
+	h.idx = h.idx[:n-1]
+	return x
+}
+
+// huffmanLengths 计算不超过 maxLen 的哈夫曼码长
+// 超长时抬高小频次符号的计数后重建，直到满足限制
+func huffmanLengths(counts []int, maxLen int) []uint8 {
+	adjusted := append([]int(nil), counts...)
+	for minCount := 1; ; minCount *= 2 {
+		lengths := make([]uint8, len(counts))
+		h := &huffHeap{}
+		for sym, c := range adjusted {
+			if c > 0 {
+				h.nodes = append(h.nodes, huffNode{count: c, sym: sym, left: -1, right: -1})
+				h.idx = append(h.idx, len(h.nodes)-1)
+			}
+		}
+		heap.Init(h)
+		for h.Len() > 1 {
+			a := heap.Pop(h).(int)
+			b := heap.Pop(h).(int)
+			h.nodes = append(h.nodes, huffNode{count: h.nodes[a].count + h.nodes[b].count, sym: -1, left: a, right: b})
+			heap.Push(h, len(h.nodes)-1)
+		}
+
+		maxDepth := 0
+		var walk func(n, depth int)
+		walk = func(n, depth int) {
+			node := h.nodes[n]
+			if node.sym >= 0 {
+				lengths[node.sym] = uint8(depth)
+				if depth > maxDepth {
+					maxDepth = depth
+				}
+				return
+			}
+			walk(node.left, depth+1)
+			walk(node.right, depth+1)
+		}
+		walk(len(h.nodes)-1, 0)
+
+		if maxDepth <= maxLen {
+			return lengths
+		}
+		for sym, c := range adjusted {
+			if c > 0 && c < minCount {
+				adjusted[sym] = minCount
+			}
+		}
+	}
+}
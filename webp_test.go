@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+
+	"golang.org/x/image/webp"
+)
+
+func TestEncodeWebPRoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	tests := []struct {
+		name          string
+		width, height int
+		alpha         bool
+		pixel         func(x, y int) color.NRGBA
+	}{
+		{"单像素", 1, 1, false, func(x, y int) color.NRGBA { return color.NRGBA{12, 34, 56, 0xff} }},
+		{"单像素透明", 1, 1, true, func(x, y int) color.NRGBA { return color.NRGBA{12, 34, 56, 0} }},
+		{"纯色", 16, 9, false, func(x, y int) color.NRGBA { return color.NRGBA{200, 100, 50, 0xff} }},
+		{"渐变", 7, 5, false, func(x, y int) color.NRGBA {
+			return color.NRGBA{uint8(x * 36), uint8(y * 50), uint8(x*y*7 + 3), 0xff}
+		}},
+		{"半透明渐变", 33, 17, true, func(x, y int) color.NRGBA {
+			return color.NRGBA{uint8(x * 7), uint8(y * 15), 128, uint8(x*8 + y)}
+		}},
+		{"随机噪点", 64, 48, false, func(x, y int) color.NRGBA {
+			return color.NRGBA{uint8(rng.Intn(256)), uint8(rng.Intn(256)), uint8(rng.Intn(256)), 0xff}
+		}},
+		{"随机噪点带透明", 300, 200, true, func(x, y int) color.NRGBA {
+			return color.NRGBA{uint8(rng.Intn(256)), uint8(rng.Intn(256)), uint8(rng.Intn(256)), uint8(rng.Intn(256))}
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := image.NewNRGBA(image.Rect(0, 0, tt.width, tt.height))
+			for y := 0; y < tt.height; y++ {
+				for x := 0; x < tt.width; x++ {
+					src.SetNRGBA(x, y, tt.pixel(x, y))
+				}
+			}
+
+			var buf bytes.Buffer
+			if err := EncodeWebP(&buf, src); err != nil {
+				t.Fatal(err)
+			}
+			// VP8L 头部第 28 位标记是否使用透明通道
+			if hasAlpha := binary.LittleEndian.Uint32(buf.Bytes()[21:25])>>28&1 == 1; hasAlpha != tt.alpha {
+				t.Errorf("透明标记为 %v, 期望 %v", hasAlpha, tt.alpha)
+			}
+			img, err := webp.Decode(bytes.NewReader(buf.Bytes()))
+			if err != nil {
+				t.Fatalf("解码失败: %v", err)
+			}
+			if img.Bounds() != src.Bounds() {
+				t.Fatalf("尺寸不一致: %v, 期望 %v", img.Bounds(), src.Bounds())
+			}
+			got, ok := img.(*image.NRGBA)
+			if !ok {
+				t.Fatalf("无损 WebP 应解码为 NRGBA: %T", img)
+			}
+			for y := 0; y < tt.height; y++ {
+				for x := 0; x < tt.width; x++ {
+					if c := got.NRGBAAt(x, y); c != src.NRGBAAt(x, y) {
+						t.Fatalf("像素 (%d, %d) 为 %v, 期望 %v", x, y, c, src.NRGBAAt(x, y))
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestEncodeWebPRejectsInvalidSize(t *testing.T) {
+	for _, r := range []image.Rectangle{image.Rect(0, 0, 0, 10), image.Rect(0, 0, 1<<14+1, 1)} {
+		if err := EncodeWebP(&bytes.Buffer{}, image.NewNRGBA(r)); err == nil {
+			t.Errorf("尺寸 %v 应当报错", r.Size())
+		}
+	}
+}